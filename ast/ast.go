@@ -3,7 +3,13 @@
 // license that can be found in the LICENSE file.
 package ast
 
-import "github.com/hulo-io/fishparser/token"
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hulo-io/fishparser/token"
+)
 
 type Node interface {
 	Pos() token.Pos
@@ -25,38 +31,144 @@ type Expr interface {
 	exprNode()
 }
 
+// A CommentGroup represents a sequence of comments
+// with no other tokens and no empty lines between.
 type CommentGroup struct {
-	List []*Comment
+	List []*Comment // len(List) > 0
 }
 
 func (g *CommentGroup) Pos() token.Pos { return g.List[0].Pos() }
 func (g *CommentGroup) End() token.Pos { return g.List[len(g.List)-1].End() }
 
+// Text returns the text of the comment group. The comment markers
+// ("#") and a single space following them are removed, as are
+// trailing blank lines. Comment lines are joined by newlines and
+// the result ends in a newline unless it is empty.
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+	lines := make([]string, 0, len(g.List))
+	for _, c := range g.List {
+		text := strings.TrimPrefix(c.Text, "#")
+		text = strings.TrimPrefix(text, " ")
+		lines = append(lines, strings.TrimRight(text, " \t"))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// A Comment node represents a single #-style comment.
 type Comment struct {
 	Hash token.Pos // position of "#"
-	Text string
+	Text string    // comment text, including the "#"
 }
 
 func (c *Comment) Pos() token.Pos { return c.Hash }
 func (c *Comment) End() token.Pos { return token.Pos(int(c.Hash) + len(c.Text)) }
 
-// A FuncDecl node represents a function declaration.
+// A FuncDecl node represents a function declaration. Its options are
+// kept by kind; an option that may be repeated, such as the events
+// of --on-event, has a list. The words that are not options are Args,
+// which fish takes as more argument names.
 type FuncDecl struct {
-	Function token.Pos // position of "function"
-	Name     *Ident
-	Recv     []Expr
-	Body     *BlockStmt
-	EndPos   token.Pos // position of "end"
+	Doc              *CommentGroup // associated documentation; or nil
+	Function         token.Pos     // position of "function"
+	Name             *Ident
+	Description      *FuncOpt   // -d, --description TEXT; or nil
+	ArgNames         []*FuncOpt // -a, --argument-names NAME...
+	Wraps            []*FuncOpt // -w, --wraps COMMAND
+	OnEvent          []*FuncOpt // -e, --on-event EVENT
+	OnVariable       []*FuncOpt // -v, --on-variable NAME
+	OnSignal         []*FuncOpt // -s, --on-signal SIGNAL
+	OnJobExit        []*FuncOpt // -j, --on-job-exit PID
+	OnProcessExit    []*FuncOpt // -p, --on-process-exit PID
+	InheritVariable  []*FuncOpt // -V, --inherit-variable NAME
+	NoScopeShadowing *FuncOpt   // -S, --no-scope-shadowing; or nil
+	DashDash         token.Pos  // position of "--" ending the options; or NoPos
+	Args             []Expr     // words that are not options
+	Body             *BlockStmt
+	EndPos           token.Pos // position of "end"
+
+	// Recv holds the words following the name, which are printed
+	// after it.
+	//
+	// Deprecated: the parser no longer sets Recv, but keeps the
+	// options by kind and the other words in Args.
+	Recv []Expr
+}
+
+// A FuncOpt node represents an option of a function declaration, such
+// as "--on-event fish_prompt", "-a name" or "--wraps=git". Opt is the
+// option as written; the value follows it in the same word after "="
+// if Eq is valid. As in fish, -a takes the words up to the next option
+// as more argument names, which are its Names.
+type FuncOpt struct {
+	OptPos token.Pos // position of the option
+	Opt    string    // option name, such as "-d" or "--description"
+	Eq     token.Pos // position of "=" of "--opt=value"; or NoPos
+	Value  Expr      // value of the option; or nil for --no-scope-shadowing
+	Names  []Expr    // argument names following the value of -a; or nil
 }
 
 func (d *FuncDecl) Pos() token.Pos { return d.Function }
 
-func (d *FuncDecl) End() token.Pos { return d.Body.Closing }
+func (d *FuncDecl) End() token.Pos {
+	if d.EndPos.IsValid() {
+		return d.EndPos + token.Pos(len(token.END))
+	}
+	return d.Body.End()
+}
 
 func (*FuncDecl) declNode() {}
 
+func (o *FuncOpt) Pos() token.Pos { return o.OptPos }
+
+func (o *FuncOpt) End() token.Pos {
+	if len(o.Names) > 0 {
+		return o.Names[len(o.Names)-1].End()
+	}
+	if o.Value != nil {
+		return o.Value.End()
+	}
+	return o.OptPos + token.Pos(len(o.Opt))
+}
+
+// Opts returns the options of d in source order. Options without
+// positions are ordered by kind as in the FuncDecl fields, with the
+// argument names last.
+func (d *FuncDecl) Opts() []*FuncOpt {
+	var list []*FuncOpt
+	if d.Description != nil {
+		list = append(list, d.Description)
+	}
+	for _, opts := range [][]*FuncOpt{d.Wraps, d.OnEvent, d.OnVariable, d.OnSignal, d.OnJobExit, d.OnProcessExit, d.InheritVariable} {
+		list = append(list, opts...)
+	}
+	if d.NoScopeShadowing != nil {
+		list = append(list, d.NoScopeShadowing)
+	}
+	list = append(list, d.ArgNames...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].OptPos < list[j].OptPos })
+	return list
+}
+
 type (
+	// A BadStmt node is a placeholder for statements containing
+	// syntax errors for which no correct statement nodes can be
+	// created.
+	BadStmt struct {
+		From, To token.Pos // position range of bad statement
+	}
+
 	// A AssignStmt node represents a assign statement.
+	//
+	// Deprecated: fish has no name=value statement; use SetStmt.
 	AssignStmt struct {
 		Local  bool
 		Lhs    Expr
@@ -64,6 +176,24 @@ type (
 		Rhs    Expr
 	}
 
+	// A SetStmt node represents a set command that assigns, erases
+	// or queries variables, such as "set -gx PATH ~/bin $PATH",
+	// "set list[2 3] a b" or "set -e tmp". Set commands that list
+	// variables or whose names are not literal are kept as a
+	// CallExpr.
+	SetStmt struct {
+		Set      token.Pos // position of "set"
+		Scope    VarScope
+		Export   bool // -x or --export
+		Unexport bool // -u or --unexport
+		Path     bool // --path
+		Unpath   bool // --unpath
+		Op       SetOp
+		Vars     []*VarRef   // exactly one unless Op is SetErase or SetQuery
+		Values   []Expr      // assigned values; or nil
+		Redirs   []*Redirect // or nil
+	}
+
 	// A BlockStmt node represents a block statement.
 	BlockStmt struct {
 		Tok     token.Token // Token.NONE | Token.LBRACE
@@ -77,10 +207,26 @@ type (
 		X Expr
 	}
 
+	// A DeclStmt node represents a function declaration in a block,
+	// such as a fallback defined in an if statement. The functions of
+	// a file are in its Decls.
+	DeclStmt struct {
+		Decl Decl
+	}
+
 	// A ReturnStmt node represents a return statement.
 	ReturnStmt struct {
-		Return token.Pos // position of "return"
-		X      Expr
+		Return token.Pos   // position of "return"
+		X      Expr        // status; or nil
+		Redirs []*Redirect // or nil
+	}
+
+	// An ExitStmt node represents a call of the exit builtin, which
+	// ends fish with the status X, or that of the last command.
+	ExitStmt struct {
+		Exit   token.Pos   // position of "exit"
+		X      Expr        // status; or nil
+		Redirs []*Redirect // or nil
 	}
 
 	// A BreakStmt node represents a break statement.
@@ -93,53 +239,90 @@ type (
 		Continue token.Pos // position of "continue"
 	}
 
+	// A BeginStmt node represents a begin block, which groups
+	// statements and opens a new scope for local variables.
+	BeginStmt struct {
+		Doc    *CommentGroup // associated documentation; or nil
+		Begin  token.Pos     // position of "begin"
+		Body   *BlockStmt
+		EndPos token.Pos   // position of "end"
+		Redirs []*Redirect // redirections after "end"; or nil
+	}
+
 	// A WhileStmt node represents a while statement.
 	WhileStmt struct {
-		While  token.Pos // position of "while"
+		Doc    *CommentGroup // associated documentation; or nil
+		While  token.Pos     // position of "while"
 		Cond   Expr
 		Body   *BlockStmt
-		EndPos token.Pos // position of "end"
+		EndPos token.Pos   // position of "end"
+		Redirs []*Redirect // redirections after "end"; or nil
 	}
 
 	// A ForeachStmt node represents a foreach statement.
 	ForeachStmt struct {
-		For    token.Pos // position of "for"
+		Doc    *CommentGroup // associated documentation; or nil
+		For    token.Pos     // position of "for"
 		Elem   Expr
 		In     token.Pos // position of "in"
 		Group  []Expr
 		Body   *BlockStmt
-		EndPos token.Pos // position of "end"
+		EndPos token.Pos   // position of "end"
+		Redirs []*Redirect // redirections after "end"; or nil
 	}
 
 	// An IfStmt node represents an if statement.
 	IfStmt struct {
-		If     token.Pos // position of "if"
+		Doc    *CommentGroup // associated documentation; or nil
+		If     token.Pos     // position of "if"
 		Cond   Expr
 		Body   *BlockStmt
-		Elif   []*IfStmt
-		Else   *BlockStmt
-		EndPos token.Pos // position of "end"
+		Elif   []*ElseIfClause
+		Else   *ElseClause // or nil
+		EndPos token.Pos   // position of "end"
+		Redirs []*Redirect // redirections after "end"; or nil
 	}
 
-	// A SwitchStmt node represents a switch statement.
+	// An ElseIfClause represents an "else if" branch of an if statement.
+	ElseIfClause struct {
+		Doc  *CommentGroup // associated documentation; or nil
+		Else token.Pos     // position of "else"
+		If   token.Pos     // position of "if"
+		Cond Expr
+		Body *BlockStmt
+	}
+
+	// An ElseClause represents the "else" branch of an if statement.
+	ElseClause struct {
+		Doc  *CommentGroup // associated documentation; or nil
+		Else token.Pos     // position of "else"
+		Body *BlockStmt
+	}
+
+	// A SwitchStmt node represents a switch statement. The body of
+	// the first case with a pattern matching Value is run; a catch-all
+	// case is written with the pattern '*'.
 	SwitchStmt struct {
-		Switch token.Pos // position of "switch"
-		Lparen token.Pos // position of "("
-		Var    Expr
-		Rparen token.Pos // position of ")"
+		Doc    *CommentGroup // associated documentation; or nil
+		Switch token.Pos     // position of "switch"
+		Value  Expr          // value matched against the patterns
 		Cases  []*CaseClause
-		Else   *BlockStmt
-		EndPos token.Pos // position of "end"
+		EndPos token.Pos   // position of "end"
+		Redirs []*Redirect // redirections after "end"; or nil
 	}
 
+	// A CaseClause represents a case of a switch statement.
 	CaseClause struct {
-		Case  token.Pos // position of "case"
-		Conds []Expr
-		Body  *BlockStmt
+		Doc      *CommentGroup // associated documentation; or nil
+		Case     token.Pos     // position of "case"
+		Patterns []Expr        // words, whose wildcards are matched quoted or not
+		Body     *BlockStmt
 	}
 )
 
+func (s *BadStmt) Pos() token.Pos    { return s.From }
 func (s *AssignStmt) Pos() token.Pos { return s.Lhs.Pos() }
+func (s *SetStmt) Pos() token.Pos    { return s.Set }
 func (s *BlockStmt) Pos() token.Pos {
 	if s.Opening.IsValid() {
 		return s.Opening
@@ -150,47 +333,144 @@ func (s *BlockStmt) Pos() token.Pos {
 	return token.NoPos
 }
 func (s *ExprStmt) Pos() token.Pos     { return s.X.Pos() }
+func (s *DeclStmt) Pos() token.Pos     { return s.Decl.Pos() }
 func (s *ReturnStmt) Pos() token.Pos   { return s.Return }
+func (s *ExitStmt) Pos() token.Pos     { return s.Exit }
 func (s *BreakStmt) Pos() token.Pos    { return s.Break }
 func (s *ContinueStmt) Pos() token.Pos { return s.Continue }
+func (s *BeginStmt) Pos() token.Pos    { return s.Begin }
 func (s *WhileStmt) Pos() token.Pos    { return s.While }
 func (s *ForeachStmt) Pos() token.Pos  { return s.For }
 func (s *IfStmt) Pos() token.Pos       { return s.If }
+func (s *ElseClause) Pos() token.Pos   { return s.Else }
 func (s *SwitchStmt) Pos() token.Pos   { return s.Switch }
+func (s *CaseClause) Pos() token.Pos   { return s.Case }
+func (s *ElseIfClause) Pos() token.Pos {
+	if s.Else.IsValid() {
+		return s.Else
+	}
+	return s.If
+}
 
+func (s *BadStmt) End() token.Pos    { return s.To }
 func (s *AssignStmt) End() token.Pos { return s.Rhs.End() }
+func (s *SetStmt) End() token.Pos {
+	end := s.Set + token.Pos(len("set"))
+	if len(s.Vars) > 0 {
+		end = s.Vars[len(s.Vars)-1].End()
+	}
+	if len(s.Values) > 0 {
+		end = s.Values[len(s.Values)-1].End()
+	}
+	if len(s.Redirs) > 0 {
+		if e := s.Redirs[len(s.Redirs)-1].End(); e > end {
+			end = e
+		}
+	}
+	return end
+}
 func (s *BlockStmt) End() token.Pos {
 	if s.Closing.IsValid() {
 		return s.Closing
 	}
 	if len(s.List) > 0 {
-		return s.List[len(s.List)-1].Pos()
+		return s.List[len(s.List)-1].End()
 	}
 	return token.NoPos
 }
-func (s *ExprStmt) End() token.Pos     { return s.X.End() }
-func (s *ReturnStmt) End() token.Pos   { return s.X.End() }
-func (s *BreakStmt) End() token.Pos    { return s.Break }
-func (s *ContinueStmt) End() token.Pos { return s.Continue }
-func (s *WhileStmt) End() token.Pos    { return s.EndPos }
-func (s *ForeachStmt) End() token.Pos  { return s.EndPos }
-func (s *IfStmt) End() token.Pos       { return s.EndPos }
-func (s *SwitchStmt) End() token.Pos   { return s.EndPos }
+func (s *ExprStmt) End() token.Pos { return s.X.End() }
+func (s *DeclStmt) End() token.Pos { return s.Decl.End() }
+func (s *ReturnStmt) End() token.Pos {
+	end := s.Return + token.Pos(len(token.RETURN))
+	if s.X != nil {
+		end = s.X.End()
+	}
+	if len(s.Redirs) > 0 {
+		if e := s.Redirs[len(s.Redirs)-1].End(); e > end {
+			end = e
+		}
+	}
+	return end
+}
+func (s *ExitStmt) End() token.Pos {
+	end := s.Exit + token.Pos(len("exit"))
+	if s.X != nil {
+		end = s.X.End()
+	}
+	if len(s.Redirs) > 0 {
+		if e := s.Redirs[len(s.Redirs)-1].End(); e > end {
+			end = e
+		}
+	}
+	return end
+}
+func (s *BreakStmt) End() token.Pos    { return s.Break + token.Pos(len(token.BREAK)) }
+func (s *ContinueStmt) End() token.Pos { return s.Continue + token.Pos(len(token.CONTINUE)) }
+func (s *BeginStmt) End() token.Pos    { return blockEnd(s.EndPos, s.Redirs) }
+func (s *WhileStmt) End() token.Pos    { return blockEnd(s.EndPos, s.Redirs) }
+func (s *ForeachStmt) End() token.Pos  { return blockEnd(s.EndPos, s.Redirs) }
+func (s *SwitchStmt) End() token.Pos   { return blockEnd(s.EndPos, s.Redirs) }
+func (s *IfStmt) End() token.Pos       { return blockEnd(s.EndPos, s.Redirs) }
+func (s *ElseIfClause) End() token.Pos {
+	if end := s.Body.End(); end.IsValid() {
+		return end
+	}
+	return s.Cond.End()
+}
+func (s *ElseClause) End() token.Pos {
+	if end := s.Body.End(); end.IsValid() {
+		return end
+	}
+	return s.Else + token.Pos(len(token.ELSE))
+}
+func (s *CaseClause) End() token.Pos {
+	if end := s.Body.End(); end.IsValid() {
+		return end
+	}
+	if len(s.Patterns) > 0 {
+		return s.Patterns[len(s.Patterns)-1].End()
+	}
+	return s.Case + token.Pos(len(token.CASE))
+}
+
+// blockEnd returns the end of a block closed by the "end" at pos
+// and followed by the redirections redirs.
+func blockEnd(pos token.Pos, redirs []*Redirect) token.Pos {
+	if len(redirs) > 0 {
+		return redirs[len(redirs)-1].End()
+	}
+	return pos + token.Pos(len(token.END))
+}
 
+func (*BadStmt) stmtNode()      {}
 func (*AssignStmt) stmtNode()   {}
+func (*SetStmt) stmtNode()      {}
 func (*BlockStmt) stmtNode()    {}
 func (*ExprStmt) stmtNode()     {}
+func (*DeclStmt) stmtNode()     {}
 func (*ReturnStmt) stmtNode()   {}
+func (*ExitStmt) stmtNode()     {}
 func (*BreakStmt) stmtNode()    {}
 func (*ContinueStmt) stmtNode() {}
+func (*BeginStmt) stmtNode()    {}
 func (*WhileStmt) stmtNode()    {}
 func (*ForeachStmt) stmtNode()  {}
 func (*IfStmt) stmtNode()       {}
+func (*ElseIfClause) stmtNode() {}
+func (*ElseClause) stmtNode()   {}
 func (*SwitchStmt) stmtNode()   {}
+func (*CaseClause) stmtNode()   {}
 
 type (
 	// Word string
 
+	// A BadExpr node is a placeholder for an expression containing
+	// syntax errors for which a correct expression node cannot be
+	// created.
+	BadExpr struct {
+		From, To token.Pos // position range of bad expression
+	}
+
 	// A BinaryExpr node represents a binary expression.
 	BinaryExpr struct {
 		Compress bool
@@ -200,10 +480,183 @@ type (
 		Y        Expr        // right operand
 	}
 
-	// A CallExpr node represents a call expression.
+	// A CallExpr node represents a call expression. Arguments and
+	// redirections may be interleaved in the source; each list keeps
+	// its own source order.
 	CallExpr struct {
-		Func *Ident
-		Recv []Expr
+		Func   *Ident
+		Recv   []Expr
+		Redirs []*Redirect // or nil
+	}
+
+	// A StatusCall node represents a call of the status builtin with a
+	// known subcommand, such as "status is-interactive" or, in its
+	// option form, "status --current-filename". Other calls of status
+	// are CallExprs.
+	StatusCall struct {
+		Status token.Pos   // position of "status"
+		Sub    *Ident      // subcommand as written
+		Args   []Expr      // arguments of the subcommand
+		Redirs []*Redirect // or nil
+	}
+
+	// A Redirect node represents a redirection of a file descriptor,
+	// such as "<input", "2>>log", "&>/dev/null" or "2>&1". For the
+	// operators "<&" and ">&", Word is the file descriptor that Fd
+	// is made a copy of, or "-" to close Fd.
+	Redirect struct {
+		OpPos token.Pos   // position of Op, or of Fd if it is written
+		Fd    int         // redirected file descriptor; Op.DefaultFd() if not written
+		Op    token.Token // redirection operator, without the file descriptor
+		Word  Expr        // target of the redirection
+	}
+
+	// A Word node represents an argument of a command: literal, quoted,
+	// expanded and substituted parts written one after another, such
+	// as `"$HOME"/bin/(uname)`. The parser returns every argument as a
+	// Word, even one of a single part.
+	Word struct {
+		Parts []Expr // *Lit, *Glob, *SingleQuoted, *DoubleQuoted, *VarExpansion, *CmdSubst or *BraceExp
+	}
+
+	// A Lit node represents unquoted text of a word, or text between
+	// double quotes. Escape sequences are kept as written.
+	Lit struct {
+		ValuePos token.Pos // literal position
+		Value    string
+	}
+
+	// A Glob node represents unquoted text of a word that holds the
+	// wildcards "*" or "**", such as the "*.fish" of "conf.d/*.fish".
+	// Escape sequences are kept as written. A "?" does not make a Glob,
+	// as it is only a wildcard with fish's qmark-noglob feature off;
+	// the unquoted text holding it is a Lit, or a Glob for its "*".
+	Glob struct {
+		ValuePos token.Pos // position of the text
+		Value    string
+	}
+
+	// A SingleQuoted node represents a '...' string, in which nothing
+	// is expanded. Value is the text between the quotes, with its
+	// escape sequences kept as written.
+	SingleQuoted struct {
+		Lquote token.Pos // position of "'"
+		Value  string
+		Rquote token.Pos // position of "'"
+	}
+
+	// A DoubleQuoted node represents a "..." string, in which variables
+	// and $( ) command substitutions are expanded.
+	DoubleQuoted struct {
+		Lquote token.Pos // position of `"`
+		Parts  []Expr    // *Lit, *VarExpansion or *CmdSubst
+		Rquote token.Pos // position of `"`
+	}
+
+	// A BraceExp node represents a brace expansion, such as "{a,b,c}"
+	// or the "{,.bak}" of "file{,.bak}". Braces without a comma between
+	// them are not expanded: they are literal text, or delimit a
+	// variable as in "{$name}". Blanks around the items are dropped.
+	BraceExp struct {
+		Lbrace token.Pos   // position of "{"
+		Elems  []*Word     // items, which may be empty; len(Elems) > 1
+		Commas []token.Pos // positions of ","
+		Rbrace token.Pos   // position of "}"
+	}
+
+	// A VarRef node represents a variable name with an optional
+	// list index, as in the arguments "PATH" or "list[2 3]" of set.
+	VarRef struct {
+		Name  *Ident
+		Index *IndexExpr // or nil
+	}
+
+	// A VarExpansion node represents the expansion of a variable,
+	// such as "$PATH", "$argv[2..-1]" or "{$name}". The expansion
+	// "$$name" uses the value of $name as the variable name; its X
+	// is the inner expansion, which takes the index brackets.
+	VarExpansion struct {
+		Lbrace token.Pos    // position of "{" of "{$name}"; or NoPos
+		Dollar token.Pos    // position of "$"
+		X      Expr         // *Ident, or *VarExpansion for "$$"
+		Index  []*IndexExpr // successive list indices; or nil
+		Rbrace token.Pos    // position of "}" of "{$name}"; or NoPos
+	}
+
+	// An IndexExpr node represents the index brackets of a list, such
+	// as "[1]", "[-1]", "[1 3 5]" or "[2..-1]".
+	IndexExpr struct {
+		Lbrack token.Pos // position of "["
+		List   []Expr    // indices, each a word or a *RangeExpr
+		Rbrack token.Pos // position of "]"
+	}
+
+	// A RangeExpr node represents a range of list indices, such as
+	// "2..-1". A missing Low or High is the first or last element.
+	RangeExpr struct {
+		Low  Expr      // or nil
+		Dots token.Pos // position of ".."
+		High Expr      // or nil
+	}
+
+	// A Pipeline node represents commands joined by pipes, such as
+	// "ls | sort", "make 2>| less" or "make &| less".
+	Pipeline struct {
+		Cmds  []Expr  // commands and *StmtExpr blocks; len(Cmds) > 1
+		Pipes []*Pipe // pipes between the commands; len(Pipes) == len(Cmds)-1
+	}
+
+	// A Pipe node represents a pipe of a Pipeline. The "&|" pipe
+	// connects both standard output and standard error; its Fd is 1.
+	Pipe struct {
+		OpPos token.Pos   // position of Op, or of Fd if it is written
+		Fd    int         // piped file descriptor; 1 if not written
+		Op    token.Token // token.BITOR, token.GT_PIPE or token.AND_PIPE
+	}
+
+	// A Job node represents a command or pipeline that is negated
+	// with "not" or "!", or run in the background with a trailing "&".
+	// A job negated more than once is a Job of a Job.
+	Job struct {
+		NotPos token.Pos   // position of NotTok; or NoPos
+		NotTok token.Token // token.NOT_KW or token.BITNOT; or token.NONE
+		X      Expr        // a command, a *StmtExpr, a *Pipeline or a negated *Job
+		Amp    token.Pos   // position of the trailing "&"; or NoPos
+	}
+
+	// A JobConjunction node represents jobs joined by "&&" and "||",
+	// or decorated with a leading "and" or "or". Fish evaluates the
+	// operators from left to right, without precedence.
+	JobConjunction struct {
+		DecPos token.Pos     // position of DecTok; or NoPos
+		DecTok token.Token   // token.AND_KW or token.OR_KW; or token.NONE
+		Jobs   []Expr        // commands, pipelines or jobs; len(Jobs) > 0
+		OpPos  []token.Pos   // positions of Ops
+		Ops    []token.Token // token.AND or token.OR; len(Ops) == len(Jobs)-1
+	}
+
+	// A StmtExpr node represents a statement run as a command of a
+	// job: a begin, while, for, if or switch block, or a return, exit,
+	// break or continue statement, as in
+	//
+	//	test -n "$x"; and return 1
+	//	ls | while read -l f; echo $f; end
+	//
+	// A statement that is a job by itself is not wrapped in a StmtExpr.
+	StmtExpr struct {
+		Stmt Stmt
+	}
+
+	// A JobList node represents the condition of an if or while
+	// statement when it takes more than a job: a job or begin block
+	// followed by the jobs decorated with "and" or "or" that continue
+	// it after a ";" or a newline, as in
+	//
+	//	if set -q foo; and test -n "$foo"
+	//
+	// Its status is that of the last job run.
+	JobList struct {
+		List []Stmt // *ExprStmt jobs and *BeginStmt blocks; len(List) > 0 if parsed
 	}
 
 	// A Ident node represents an identifier expression.
@@ -214,9 +667,9 @@ type (
 
 	// A BasicLit node represents a literal of basic type.
 	BasicLit struct {
-		Kind     token.Token // Token.Empty | Token.Null | Token.Boolean | Token.Byte | Token.Integer | Token.Currency | Token.Long | Token.Single | Token.Double | Token.Date | Token.String | Token.Object | Token.Error
-		Value    string
-		ValuePos token.Pos // literal position
+		Kind     token.Token // Token.STRING | Token.NUMBER | Token.SINGLE_QUOTE | Token.DOUBLE_QUOTE
+		Value    string      // quoted kinds keep their quotes, as in the source
+		ValuePos token.Pos   // literal position
 	}
 
 	// [ ]
@@ -249,21 +702,27 @@ type (
 	// Command Grouping: { }
 	//
 	// A CmdGroup node represents a command group expression.
+	// The body of a command substitution holding more than one
+	// statement is a CmdGroup without braces.
 	CmdGroup struct {
 		Lbrace token.Pos // position of "{"
 		List   []Stmt
 		Rbrace token.Pos // position of "}"
 	}
 
-	// Command Substitution: $( ) or ` `
+	// Command Substitution: (cmd) or $(cmd)
 	//
-	// A CmdSubst node represents a command substitution expression.
+	// A CmdSubst node represents a command substitution, "(cmd)" or,
+	// since fish 3.4, "$(cmd)". Only the latter may appear between
+	// double quotes. Unquoted, the output is split into one argument
+	// per line; quoted, it is a single argument without its trailing
+	// newlines.
 	CmdSubst struct {
-		Dollar  token.Pos
-		Tok     token.Token // Token.LPAREN or Token.BACK_QUOTE
-		Opening token.Pos
-		X       Expr
-		Closing token.Pos
+		Dollar token.Pos // position of "$"; or NoPos for "(cmd)"
+		Lparen token.Pos // position of "("
+		X      Expr      // substituted job, or *CmdGroup of several statements
+		Rparen token.Pos // position of ")"
+		Quoted bool      // between double quotes; always written "$(cmd)"
 	}
 
 	// Process Substitution: <( ) or >( )
@@ -289,7 +748,8 @@ type (
 
 	// Parameter Expandsion: ${}
 	//
-	// A ParamExp node represents an parameter expansion expression.
+	// A ParamExp node represents a bash parameter expansion expression.
+	// Fish variables are expanded with a VarExpansion.
 	ParamExp struct {
 		Dollar               token.Pos // position of "$"
 		Lbrace               token.Pos // position of "{"
@@ -424,49 +884,361 @@ type (
 )
 
 // func (x Word) Pos() token.Pos              { return token.NoPos }
+func (x *BadExpr) Pos() token.Pos          { return x.From }
 func (x *BinaryExpr) Pos() token.Pos       { return x.X.Pos() }
 func (x *CallExpr) Pos() token.Pos         { return x.Func.NamePos }
+func (x *StatusCall) Pos() token.Pos       { return x.Status }
+func (x *Redirect) Pos() token.Pos         { return x.OpPos }
+func (x *Word) Pos() token.Pos             { return x.Parts[0].Pos() }
+func (x *Lit) Pos() token.Pos              { return x.ValuePos }
+func (x *Glob) Pos() token.Pos             { return x.ValuePos }
+func (x *SingleQuoted) Pos() token.Pos     { return x.Lquote }
+func (x *DoubleQuoted) Pos() token.Pos     { return x.Lquote }
+func (x *BraceExp) Pos() token.Pos         { return x.Lbrace }
+func (x *VarRef) Pos() token.Pos           { return x.Name.Pos() }
+func (x *IndexExpr) Pos() token.Pos        { return x.Lbrack }
+func (x *Pipeline) Pos() token.Pos         { return x.Cmds[0].Pos() }
+func (x *Pipe) Pos() token.Pos             { return x.OpPos }
 func (x *Ident) Pos() token.Pos            { return x.NamePos }
 func (x *BasicLit) Pos() token.Pos         { return x.ValuePos }
 func (x *BasicTestExpr) Pos() token.Pos    { return x.Lbrack }
 func (x *ExtendedTestExpr) Pos() token.Pos { return x.Lbrack }
 func (x *ArithEvalExpr) Pos() token.Pos    { return x.Lparen }
-func (x *CmdSubst) Pos() token.Pos         { return x.Dollar }
-func (x *ProcSubst) Pos() token.Pos        { return x.TokPos }
-func (x *ArithExp) Pos() token.Pos         { return x.Dollar }
-func (x *ParamExp) Pos() token.Pos         { return x.Dollar }
+func (x *StmtExpr) Pos() token.Pos         { return x.Stmt.Pos() }
+func (x *JobList) Pos() token.Pos {
+	if len(x.List) == 0 {
+		return token.NoPos
+	}
+	return x.List[0].Pos()
+}
+func (x *CmdGroup) Pos() token.Pos {
+	if x.Lbrace.IsValid() || len(x.List) == 0 {
+		return x.Lbrace
+	}
+	return x.List[0].Pos()
+}
+func (x *CmdSubst) Pos() token.Pos {
+	if x.Dollar.IsValid() {
+		return x.Dollar
+	}
+	return x.Lparen
+}
+func (x *ProcSubst) Pos() token.Pos { return x.TokPos }
+func (x *ArithExp) Pos() token.Pos  { return x.Dollar }
+func (x *ParamExp) Pos() token.Pos  { return x.Dollar }
+func (x *Job) Pos() token.Pos {
+	if x.NotPos.IsValid() {
+		return x.NotPos
+	}
+	return x.X.Pos()
+}
+func (x *VarExpansion) Pos() token.Pos {
+	if x.Lbrace.IsValid() {
+		return x.Lbrace
+	}
+	return x.Dollar
+}
+func (x *RangeExpr) Pos() token.Pos {
+	if x.Low != nil {
+		return x.Low.Pos()
+	}
+	return x.Dots
+}
+func (x *JobConjunction) Pos() token.Pos {
+	if x.DecPos.IsValid() {
+		return x.DecPos
+	}
+	return x.Jobs[0].Pos()
+}
 
 // func (x Word) End() token.Pos        { return token.NoPos }
+func (x *BadExpr) End() token.Pos    { return x.To }
 func (x *BinaryExpr) End() token.Pos { return x.Y.End() }
 func (x *CallExpr) End() token.Pos {
+	end := x.Func.End()
 	if len(x.Recv) > 0 {
-		return x.Recv[len(x.Recv)-1].End()
+		end = x.Recv[len(x.Recv)-1].End()
 	}
-	return token.NoPos
+	if len(x.Redirs) > 0 {
+		if e := x.Redirs[len(x.Redirs)-1].End(); e > end {
+			end = e
+		}
+	}
+	return end
 }
+func (x *StatusCall) End() token.Pos {
+	end := x.Status + token.Pos(len("status"))
+	if x.Sub != nil {
+		end = x.Sub.End()
+	}
+	if len(x.Args) > 0 {
+		end = x.Args[len(x.Args)-1].End()
+	}
+	if len(x.Redirs) > 0 {
+		if e := x.Redirs[len(x.Redirs)-1].End(); e > end {
+			end = e
+		}
+	}
+	return end
+}
+func (x *Redirect) End() token.Pos         { return x.Word.End() }
+func (x *Word) End() token.Pos             { return x.Parts[len(x.Parts)-1].End() }
+func (x *Lit) End() token.Pos              { return token.Pos(int(x.ValuePos) + len(x.Value)) }
+func (x *Glob) End() token.Pos             { return token.Pos(int(x.ValuePos) + len(x.Value)) }
+func (x *SingleQuoted) End() token.Pos     { return x.Rquote + 1 }
+func (x *DoubleQuoted) End() token.Pos     { return x.Rquote + 1 }
+func (x *BraceExp) End() token.Pos         { return x.Rbrace + 1 }
+func (x *IndexExpr) End() token.Pos        { return x.Rbrack + 1 }
+func (x *Pipeline) End() token.Pos         { return x.Cmds[len(x.Cmds)-1].End() }
+func (x *JobConjunction) End() token.Pos   { return x.Jobs[len(x.Jobs)-1].End() }
 func (x *Ident) End() token.Pos            { return token.Pos(int(x.NamePos) + len(x.Name)) }
 func (x *BasicLit) End() token.Pos         { return token.Pos(int(x.ValuePos) + len(x.Value)) }
 func (x *BasicTestExpr) End() token.Pos    { return x.Rbrack }
 func (x *ExtendedTestExpr) End() token.Pos { return x.Rbrack }
 func (x *ArithEvalExpr) End() token.Pos    { return x.Rparen }
-func (x *CmdSubst) End() token.Pos         { return x.Closing }
-func (x *ProcSubst) End() token.Pos        { return x.Rparen }
-func (x *ArithExp) End() token.Pos         { return x.Rparen }
-func (x *ParamExp) End() token.Pos         { return x.Rbrace }
+func (x *StmtExpr) End() token.Pos         { return x.Stmt.End() }
+func (x *JobList) End() token.Pos {
+	if len(x.List) == 0 {
+		return token.NoPos
+	}
+	return x.List[len(x.List)-1].End()
+}
+func (x *CmdGroup) End() token.Pos {
+	if x.Rbrace.IsValid() {
+		return x.Rbrace + 1
+	}
+	if len(x.List) > 0 {
+		return x.List[len(x.List)-1].End()
+	}
+	return token.NoPos
+}
+func (x *CmdSubst) End() token.Pos  { return x.Rparen + 1 }
+func (x *ProcSubst) End() token.Pos { return x.Rparen }
+func (x *ArithExp) End() token.Pos  { return x.Rparen }
+func (x *ParamExp) End() token.Pos  { return x.Rbrace }
+func (x *VarRef) End() token.Pos {
+	if x.Index != nil {
+		return x.Index.End()
+	}
+	return x.Name.End()
+}
+func (x *VarExpansion) End() token.Pos {
+	if x.Rbrace.IsValid() {
+		return x.Rbrace + 1
+	}
+	if len(x.Index) > 0 {
+		return x.Index[len(x.Index)-1].End()
+	}
+	return x.X.End()
+}
+func (x *RangeExpr) End() token.Pos {
+	if x.High != nil {
+		return x.High.End()
+	}
+	return x.Dots + 2
+}
+func (x *Pipe) End() token.Pos {
+	n := len(x.Op)
+	if x.Fd != x.Op.DefaultFd() {
+		n += len(strconv.Itoa(x.Fd))
+	}
+	return x.OpPos + token.Pos(n)
+}
+func (x *Job) End() token.Pos {
+	if x.Amp.IsValid() {
+		return x.Amp + 1
+	}
+	return x.X.End()
+}
 
 // func (Word) exprNode()              {}
+func (*BadExpr) exprNode()          {}
 func (*BinaryExpr) exprNode()       {}
 func (*CallExpr) exprNode()         {}
+func (*StatusCall) exprNode()       {}
+func (*Redirect) exprNode()         {}
+func (*Word) exprNode()             {}
+func (*Lit) exprNode()              {}
+func (*Glob) exprNode()             {}
+func (*SingleQuoted) exprNode()     {}
+func (*DoubleQuoted) exprNode()     {}
+func (*BraceExp) exprNode()         {}
+func (*VarRef) exprNode()           {}
+func (*VarExpansion) exprNode()     {}
+func (*IndexExpr) exprNode()        {}
+func (*RangeExpr) exprNode()        {}
+func (*Pipeline) exprNode()         {}
+func (*Job) exprNode()              {}
+func (*JobConjunction) exprNode()   {}
+func (*StmtExpr) exprNode()         {}
+func (*JobList) exprNode()          {}
 func (*Ident) exprNode()            {}
 func (*BasicLit) exprNode()         {}
 func (*BasicTestExpr) exprNode()    {}
 func (*ExtendedTestExpr) exprNode() {}
 func (*ArithEvalExpr) exprNode()    {}
+func (*CmdGroup) exprNode()         {}
 func (*CmdSubst) exprNode()         {}
 func (*ProcSubst) exprNode()        {}
 func (*ArithExp) exprNode()         {}
 func (*ParamExp) exprNode()         {}
 
+// A VarScope is the scope option of a set command.
+type VarScope int
+
+const (
+	ScopeDefault   VarScope = iota // no scope option
+	ScopeLocal                     // -l or --local
+	ScopeFunction                  // -f or --function
+	ScopeGlobal                    // -g or --global
+	ScopeUniversal                 // -U or --universal
+)
+
+// A SetOp is the operation of a set command.
+type SetOp int
+
+const (
+	SetAssign  SetOp = iota // replace the value of the variable
+	SetAppend               // -a or --append
+	SetPrepend              // -p or --prepend
+	SetErase                // -e or --erase
+	SetQuery                // -q or --query
+)
+
+// Options returns the options of s the way they are usually written:
+// the short options in a single group, such as "-gx", followed by
+// "--path" or "--unpath".
+func (s *SetStmt) Options() []string {
+	short := ""
+	switch s.Scope {
+	case ScopeLocal:
+		short += "l"
+	case ScopeFunction:
+		short += "f"
+	case ScopeGlobal:
+		short += "g"
+	case ScopeUniversal:
+		short += "U"
+	}
+	if s.Export {
+		short += "x"
+	}
+	if s.Unexport {
+		short += "u"
+	}
+	switch s.Op {
+	case SetAppend:
+		short += "a"
+	case SetPrepend:
+		short += "p"
+	case SetErase:
+		short += "e"
+	case SetQuery:
+		short += "q"
+	}
+
+	var opts []string
+	if short != "" {
+		opts = append(opts, "-"+short)
+	}
+	if s.Path {
+		opts = append(opts, "--path")
+	}
+	if s.Unpath {
+		opts = append(opts, "--unpath")
+	}
+	return opts
+}
+
+// Code returns the status s returns with, and reports whether it is
+// known without running fish: whether it is written as a number.
+func (s *ReturnStmt) Code() (int, bool) { return statusCode(s.X) }
+
+// Code returns the status s exits with, and reports whether it is
+// known without running fish: whether it is written as a number.
+func (s *ExitStmt) Code() (int, bool) { return statusCode(s.X) }
+
+// statusCode returns the number x is written as, if any.
+func statusCode(x Expr) (int, bool) {
+	var s string
+	switch x := x.(type) {
+	case *Ident:
+		s = x.Name
+	case *BasicLit:
+		s = x.Value
+	case *Word:
+		if len(x.Parts) != 1 {
+			return 0, false
+		}
+		lit, ok := x.Parts[0].(*Lit)
+		if !ok {
+			return 0, false
+		}
+		s = lit.Value
+	default:
+		return 0, false
+	}
+	n, err := strconv.Atoi(s)
+	return n, err == nil
+}
+
+// statusSubcommands lists the subcommands of the status builtin, with
+// the options and old names standing for them.
+var statusSubcommands = map[string][]string{
+	"basename":                   {"current-basename"},
+	"buildinfo":                  nil,
+	"current-command":            nil,
+	"current-commandline":        nil,
+	"dirname":                    {"current-dirname"},
+	"features":                   nil,
+	"filename":                   {"current-filename", "-f", "--current-filename"},
+	"fish-path":                  nil,
+	"function":                   {"current-function"},
+	"get-file":                   nil,
+	"is-block":                   {"-b", "--is-block"},
+	"is-breakpoint":              nil,
+	"is-command-substitution":    {"-c", "--is-command-substitution"},
+	"is-full-job-control":        {"--is-full-job-control"},
+	"is-interactive":             {"-i", "--is-interactive"},
+	"is-interactive-job-control": {"--is-interactive-job-control"},
+	"is-interactive-read":        nil,
+	"is-login":                   {"-l", "--is-login"},
+	"is-no-job-control":          {"--is-no-job-control"},
+	"job-control":                {"-j", "--job-control"},
+	"line-number":                {"current-line-number", "-n", "--current-line-number"},
+	"list-files":                 nil,
+	"stack-trace":                {"print-stack-trace", "-t", "--print-stack-trace"},
+	"terminal":                   nil,
+	"test-feature":               nil,
+}
+
+// statusNames maps the ways of writing the subcommands of the status
+// builtin to their names.
+var statusNames = func() map[string]string {
+	names := make(map[string]string)
+	for name, aliases := range statusSubcommands {
+		names[name] = name
+		for _, alias := range aliases {
+			names[alias] = name
+		}
+	}
+	return names
+}()
+
+// StatusSubcommand returns the name of the subcommand of the status
+// builtin that sub, a subcommand or option, stands for, such as
+// "is-interactive" for "-i". It returns "" if sub is not known.
+func StatusSubcommand(sub string) string { return statusNames[sub] }
+
+// Subcommand returns the name of the subcommand x calls, whichever
+// way it is written, or "" if it is not known.
+func (x *StatusCall) Subcommand() string {
+	if x.Sub == nil {
+		return ""
+	}
+	return StatusSubcommand(x.Sub.Name)
+}
+
 type ExpOperator string
 
 const (
@@ -512,12 +1284,42 @@ const (
 	ExpOperatork = "k"
 )
 
+// A File node represents a fish source file.
 type File struct {
-	Doc *CommentGroup
+	Doc *CommentGroup // leading comment of the file, such as a shebang; or nil
 
 	Stmts []Stmt
 	Decls []Decl
+
+	FileStart, FileEnd token.Pos       // start and end of entire file
+	Comments           []*CommentGroup // list of all comments in the source file
 }
 
-func (*File) Pos() token.Pos { return token.NoPos }
-func (*File) End() token.Pos { return token.NoPos }
+func (f *File) Pos() token.Pos { return f.FileStart }
+func (f *File) End() token.Pos { return f.FileEnd }
+
+// Nodes returns the declarations and statements of f in source order.
+// A declaration or statement without a position is taken to come
+// before the ones following it in its list, so that the declarations
+// of a file built without positions come first.
+func (f *File) Nodes() []Node {
+	nodes := make([]Node, 0, len(f.Decls)+len(f.Stmts))
+	i, j := 0, 0
+	for i < len(f.Decls) && j < len(f.Stmts) {
+		d, s := f.Decls[i], f.Stmts[j]
+		if s.Pos().IsValid() && d.Pos().IsValid() && s.Pos() < d.Pos() {
+			nodes = append(nodes, s)
+			j++
+		} else {
+			nodes = append(nodes, d)
+			i++
+		}
+	}
+	for _, d := range f.Decls[i:] {
+		nodes = append(nodes, d)
+	}
+	for _, s := range f.Stmts[j:] {
+		nodes = append(nodes, s)
+	}
+	return nodes
+}
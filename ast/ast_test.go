@@ -4,6 +4,7 @@
 package ast_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/hulo-io/fishparser/ast"
@@ -14,11 +15,23 @@ func TestStmt(t *testing.T) {
 	ast.Print(&ast.BlockStmt{
 		List: []ast.Stmt{
 			&ast.IfStmt{
-				Cond: &ast.ArithEvalExpr{
-					X: &ast.BinaryExpr{
-						X:  &ast.Ident{Name: "-d"},
-						Op: token.NONE,
-						Y:  &ast.Ident{Name: "file.txt"},
+				Cond: &ast.JobList{
+					List: []ast.Stmt{
+						&ast.ExprStmt{
+							X: &ast.CallExpr{
+								Func: &ast.Ident{Name: "test"},
+								Recv: []ast.Expr{&ast.Ident{Name: "-d"}, &ast.Ident{Name: "file.txt"}},
+							},
+						},
+						&ast.ExprStmt{
+							X: &ast.JobConjunction{
+								DecTok: token.AND_KW,
+								Jobs: []ast.Expr{&ast.CallExpr{
+									Func: &ast.Ident{Name: "test"},
+									Recv: []ast.Expr{&ast.Ident{Name: "-r"}, &ast.Ident{Name: "file.txt"}},
+								}},
+							},
+						},
 					},
 				},
 				Body: &ast.BlockStmt{
@@ -60,9 +73,9 @@ func TestPrint(t *testing.T) {
 				Body: &ast.BlockStmt{
 					List: []ast.Stmt{
 						&ast.SwitchStmt{
-							Var: &ast.Ident{Name: "$ANIMAL"},
+							Value: &ast.Ident{Name: "$ANIMAL"},
 							Cases: []*ast.CaseClause{
-								{Conds: []ast.Expr{&ast.Ident{Name: "cat"}, &ast.Ident{Name: "horse"}},
+								{Patterns: []ast.Expr{&ast.Ident{Name: "cat"}, &ast.Ident{Name: "horse"}},
 									Body: &ast.BlockStmt{
 										List: []ast.Stmt{
 											&ast.ExprStmt{
@@ -73,16 +86,17 @@ func TestPrint(t *testing.T) {
 											},
 										},
 									}},
-							},
-							Else: &ast.BlockStmt{
-								List: []ast.Stmt{
-									&ast.ExprStmt{
-										&ast.CallExpr{
-											Func: &ast.Ident{Name: "echo"},
-											Recv: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: "string"}},
+								{Patterns: []ast.Expr{&ast.Ident{Name: "*"}},
+									Body: &ast.BlockStmt{
+										List: []ast.Stmt{
+											&ast.ExprStmt{
+												&ast.CallExpr{
+													Func: &ast.Ident{Name: "echo"},
+													Recv: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: "string"}},
+												},
+											},
 										},
-									},
-								},
+									}},
 							},
 						},
 					},
@@ -109,7 +123,7 @@ func TestPrint(t *testing.T) {
 						},
 					},
 				},
-				Elif: []*ast.IfStmt{
+				Elif: []*ast.ElseIfClause{
 					{Cond: &ast.BinaryExpr{
 						X:  &ast.Ident{Name: "!"},
 						Op: token.NONE,
@@ -151,11 +165,13 @@ func TestPrint(t *testing.T) {
 						},
 					}},
 				},
-				Else: &ast.BlockStmt{
-					List: []ast.Stmt{
-						&ast.ExprStmt{
-							X: &ast.CallExpr{
-								Func: &ast.Ident{Name: "echo"},
+				Else: &ast.ElseClause{
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ExprStmt{
+								X: &ast.CallExpr{
+									Func: &ast.Ident{Name: "echo"},
+								},
 							},
 						},
 					},
@@ -164,3 +180,303 @@ func TestPrint(t *testing.T) {
 		},
 	})
 }
+
+func TestInspect(t *testing.T) {
+	file := &ast.File{
+		Decls: []ast.Decl{
+			&ast.FuncDecl{
+				Name:     &ast.Ident{Name: "greet"},
+				ArgNames: []*ast.FuncOpt{{Opt: "--argument-names", Value: &ast.Ident{Name: "who"}}},
+				Body: &ast.BlockStmt{List: []ast.Stmt{
+					&ast.ReturnStmt{},
+				}},
+			},
+		},
+		Stmts: []ast.Stmt{
+			&ast.IfStmt{
+				Cond: &ast.CallExpr{Func: &ast.Ident{Name: "true"}},
+				Body: &ast.BlockStmt{},
+				Elif: []*ast.ElseIfClause{
+					{Cond: &ast.CallExpr{Func: &ast.Ident{Name: "false"}}, Body: &ast.BlockStmt{}},
+				},
+				Else: &ast.ElseClause{Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BreakStmt{}}}},
+			},
+			&ast.ForeachStmt{
+				Elem:  &ast.Ident{Name: "f"},
+				Group: []ast.Expr{&ast.Ident{Name: "a"}, &ast.Ident{Name: "b"}},
+				Body:  &ast.BlockStmt{List: []ast.Stmt{&ast.ContinueStmt{}}},
+			},
+			&ast.SwitchStmt{
+				Value: &ast.ParamExp{
+					Var:           &ast.Ident{Name: "animal"},
+					DefaultValExp: &ast.DefaultValExp{Val: &ast.BasicLit{Kind: token.STRING, Value: "cat"}},
+				},
+				Cases: []*ast.CaseClause{
+					{Patterns: []ast.Expr{&ast.Ident{Name: "cat"}}, Body: &ast.BlockStmt{}},
+				},
+			},
+			&ast.ExprStmt{X: &ast.CmdSubst{X: &ast.BinaryExpr{
+				X:  &ast.CallExpr{Func: &ast.Ident{Name: "ls"}},
+				Op: token.BITOR,
+				Y:  &ast.CallExpr{Func: &ast.Ident{Name: "wc"}},
+			}}},
+		},
+	}
+
+	var idents []string
+	var nodes, nils int
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n == nil {
+			nils++
+			return true
+		}
+		nodes++
+		if id, ok := n.(*ast.Ident); ok {
+			idents = append(idents, id.Name)
+		}
+		return true
+	})
+
+	want := []string{"greet", "who", "true", "false", "f", "a", "b", "animal", "cat", "ls", "wc"}
+	if len(idents) != len(want) {
+		t.Fatalf("got identifiers %v, want %v", idents, want)
+	}
+	for i := range want {
+		if idents[i] != want[i] {
+			t.Errorf("identifier %d: got %s, want %s", i, idents[i], want[i])
+		}
+	}
+	if nodes != nils {
+		t.Errorf("visited %d nodes but got %d nil calls", nodes, nils)
+	}
+
+	// optional children may be missing
+	partial := []ast.Node{
+		&ast.FuncDecl{},
+		&ast.IfStmt{Elif: []*ast.ElseIfClause{{}}, Else: &ast.ElseClause{}},
+		&ast.WhileStmt{},
+		&ast.ForeachStmt{},
+		&ast.SwitchStmt{Cases: []*ast.CaseClause{{}}},
+		&ast.BeginStmt{},
+	}
+	for _, n := range partial {
+		var nodes int
+		ast.Inspect(n, func(n ast.Node) bool {
+			if n != nil {
+				nodes++
+			}
+			return true
+		})
+		if nodes == 0 {
+			t.Errorf("%T was not visited", n)
+		}
+	}
+
+	// the declarations and statements of a file are visited in source order
+	echo := func(pos token.Pos, arg string) ast.Stmt {
+		return &ast.ExprStmt{X: &ast.CallExpr{
+			Func: &ast.Ident{NamePos: pos, Name: "echo"},
+			Recv: []ast.Expr{&ast.Ident{NamePos: pos + 5, Name: arg}},
+		}}
+	}
+	// echo a; function f; end; echo b
+	f := &ast.File{
+		Decls: []ast.Decl{&ast.FuncDecl{Function: 9, Name: &ast.Ident{NamePos: 18, Name: "f"}, Body: &ast.BlockStmt{}}},
+		Stmts: []ast.Stmt{echo(1, "a"), echo(26, "b")},
+	}
+	var order []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			order = append(order, n.Recv[0].(*ast.Ident).Name)
+		case *ast.FuncDecl:
+			order = append(order, "function "+n.Name.Name)
+		}
+		return true
+	})
+	if got := strings.Join(order, ", "); got != "a, function f, b" {
+		t.Errorf("visited %s, want a, function f, b", got)
+	}
+
+	// returning false prunes the subtree
+	var calls int
+	ast.Inspect(file, func(n ast.Node) bool {
+		if _, ok := n.(*ast.CallExpr); ok {
+			calls++
+		}
+		_, isIf := n.(*ast.IfStmt)
+		return !isIf
+	})
+	if calls != 2 {
+		t.Errorf("got %d calls outside if statements, want 2", calls)
+	}
+}
+
+func TestString(t *testing.T) {
+	name := &ast.Ident{Name: "name"}
+	lit := func(s string) *ast.Ident { return &ast.Ident{Name: s} }
+	call := func(name string, args ...ast.Expr) *ast.CallExpr {
+		return &ast.CallExpr{Func: lit(name), Recv: args}
+	}
+
+	tests := []struct {
+		x    ast.Expr
+		want string
+	}{
+		{&ast.CmdSubst{X: call("date")}, "(date)"},
+		{&ast.CmdSubst{Dollar: 1, Lparen: 2, X: call("date")}, "$(date)"},
+		{&ast.DoubleQuoted{Parts: []ast.Expr{&ast.Lit{Value: "at "}, &ast.CmdSubst{X: call("date"), Quoted: true}}}, `"at $(date)"`},
+		{&ast.BasicTestExpr{X: &ast.BinaryExpr{X: lit("$a"), Op: token.EQ, Y: lit("b")}}, "test $a = b"},
+		{&ast.ExtendedTestExpr{X: &ast.BinaryExpr{
+			X:  &ast.BinaryExpr{X: lit("-f"), Op: token.NONE, Y: lit("x")},
+			Op: token.AND,
+			Y:  &ast.BinaryExpr{X: lit("-n"), Op: token.NONE, Y: lit("$y")},
+		}}, "test -f x -a -n $y"},
+		{&ast.ExtendedTestExpr{X: &ast.BinaryExpr{X: lit("$v"), Op: "=~", Y: lit("^v[0-9]+")}}, "string match -qr -- '^v[0-9]+' $v"},
+		{&ast.ArithExp{X: &ast.BinaryExpr{X: lit("$n"), Op: token.MUL, Y: lit("2")}}, `(math "$n * 2")`},
+		{&ast.ArithEvalExpr{X: lit("$n")}, "test (math $n) -ne 0"},
+		{&ast.ProcSubst{Tok: token.LT, X: call("sort", lit("a"))}, "(sort a | psub)"},
+		{&ast.ParamExp{Var: name}, "$name"},
+		{&ast.ParamExp{Var: name, LengthExp: &ast.LengthExp{}}, "(string length -- $name)"},
+		{&ast.ParamExp{Var: name, CaseConversionExp: &ast.CaseConversionExp{ToUpper: true}}, "(string upper -- $name)"},
+		{&ast.ParamExp{Var: name, ReplaceExp: &ast.ReplaceExp{All: true, Old: "-", New: "_"}}, "(string replace -a -- '-' '_' $name)"},
+		{&ast.ParamExp{Var: name, SubstringExp: &ast.SubstringExp{Offset: 1, Length: 3}}, "(string sub -s 2 -l 3 -- $name)"},
+		{&ast.ParamExp{Var: name, DelSuffix: &ast.DelSuffix{Val: lit(".*")}}, `(string replace -r -- '^(.*)\..*?$' '$1' $name)`},
+		{&ast.ParamExp{Var: name, DelPrefix: &ast.DelPrefix{Longest: true, Val: lit("*/")}}, `(string replace -r -- '^.*/' '' $name)`},
+		{&ast.BasicLit{Kind: token.STRING, Value: `costs $5 "net" in C:\tmp`}, `'costs $5 "net" in C:\tmp'`},
+		{&ast.BasicLit{Kind: token.STRING, Value: "it's"}, `it\'s`},
+		{&ast.BinaryExpr{X: call("echo", lit("hi")), Op: "2>", Y: lit("/dev/null")}, "echo hi 2>/dev/null"},
+		{&ast.BinaryExpr{X: call("ls"), Op: token.BITOR, Y: call("wc", lit("-l"))}, "ls | wc -l"},
+		{&ast.JobConjunction{
+			DecTok: token.OR_KW,
+			Jobs: []ast.Expr{
+				&ast.Job{NotTok: token.NOT_KW, X: &ast.Pipeline{
+					Cmds:  []ast.Expr{call("make"), call("grep", lit("error"))},
+					Pipes: []*ast.Pipe{{Fd: 2, Op: token.GT_PIPE}},
+				}},
+				&ast.Job{X: call("sleep", lit("1")), Amp: 1},
+			},
+			Ops: []token.Token{token.OR},
+		}, "or not make 2>| grep error || sleep 1 &"},
+		{&ast.CallExpr{Func: lit("cmd"), Recv: []ast.Expr{lit("a")}, Redirs: []*ast.Redirect{
+			{Fd: 0, Op: token.LT, Word: lit("in")},
+			{Fd: 2, Op: token.XOR, Word: lit("err")},
+			{Fd: 2, Op: token.LT_AND, Word: lit("1")},
+			{Fd: 1, Op: token.AND_DOUBLE_GT, Word: lit("log")},
+		}}, "cmd a <in 2>err 2>&1 &>>log"},
+		{&ast.StatusCall{Sub: lit("-i")}, "status -i"},
+		{&ast.StatusCall{Sub: lit("test-feature"), Args: []ast.Expr{lit("qmark-noglob")}}, "status test-feature qmark-noglob"},
+	}
+	for _, tt := range tests {
+		if got := ast.ExprStr(tt.x); got != tt.want {
+			t.Errorf("ExprStr(%T) = %s, want %s", tt.x, got, tt.want)
+		}
+	}
+
+	file := &ast.File{
+		Decls: []ast.Decl{&ast.FuncDecl{
+			Name:     lit("pick"),
+			ArgNames: []*ast.FuncOpt{{Opt: "-a", Value: lit("animal")}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.SwitchStmt{
+					Value: lit("$animal"),
+					Cases: []*ast.CaseClause{
+						{Patterns: []ast.Expr{lit("cat"), lit("li*")}, Body: &ast.BlockStmt{List: []ast.Stmt{
+							&ast.ReturnStmt{X: lit("0")},
+						}}},
+						{Patterns: []ast.Expr{lit("*")}, Body: &ast.BlockStmt{List: []ast.Stmt{
+							&ast.ReturnStmt{X: lit("1")},
+						}}},
+					},
+				},
+			}},
+		}},
+		Stmts: []ast.Stmt{
+			&ast.AssignStmt{Local: true, Lhs: lit("n"), Rhs: lit("3")},
+			&ast.IfStmt{
+				Cond: call("pick", lit("$n")),
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: call("echo", lit("yes"))}}},
+				Elif: []*ast.ElseIfClause{{
+					Cond: call("test", lit("$n"), lit("-gt"), lit("2")),
+					Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: call("echo", lit("big"))}}},
+				}},
+				Else: &ast.ElseClause{Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: call("echo", lit("no"))}}}},
+			},
+			&ast.ExitStmt{X: lit("1")},
+		},
+	}
+	want := `function pick -a animal
+    switch $animal
+        case cat 'li*'
+            return 0
+        case '*'
+            return 1
+    end
+end
+set -l n 3
+if pick $n
+    echo yes
+else if test $n -gt 2
+    echo big
+else
+    echo no
+end
+exit 1
+`
+	if got := ast.String(file); got != want {
+		t.Errorf("String(file) =\n%s\nwant\n%s", got, want)
+	}
+
+	// the optional parts of statements may be missing
+	if list := (&ast.JobList{}); list.Pos().IsValid() || list.End().IsValid() {
+		t.Errorf("empty job list spans %d-%d", list.Pos(), list.End())
+	}
+	if end := (&ast.ReturnStmt{Return: 10}).End(); end != 16 {
+		t.Errorf("return ends at %d, want 16", end)
+	}
+	if end := (&ast.ExitStmt{Exit: 10}).End(); end != 14 {
+		t.Errorf("exit ends at %d, want 14", end)
+	}
+	if end := (&ast.StatusCall{Status: 10}).End(); end != 16 {
+		t.Errorf("status ends at %d, want 16", end)
+	}
+	if code, ok := (&ast.ExitStmt{X: &ast.Word{}}).Code(); ok {
+		t.Errorf("exit with an empty word has the code %d", code)
+	}
+
+	// a PrintConfig changes the layout
+	var out strings.Builder
+	loop := &ast.WhileStmt{Cond: call("true"), Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BreakStmt{}}}}
+	if err := (&ast.PrintConfig{UseTabs: true}).Fprint(&out, nil, loop); err != nil || out.String() != "while true\n\tbreak\nend\n" {
+		t.Errorf("got %q, %v", out.String(), err)
+	}
+
+	// options without positions come before the other words; the
+	// deprecated Recv is still printed after the name
+	for _, tt := range []struct {
+		d    *ast.FuncDecl
+		want string
+	}{
+		{&ast.FuncDecl{
+			Name:     lit("greet"),
+			ArgNames: []*ast.FuncOpt{{Opt: "-a", Value: lit("who"), Names: []ast.Expr{lit("greeting")}}},
+			Args:     []ast.Expr{lit("x")},
+			Body:     &ast.BlockStmt{},
+		}, "function greet -a who greeting x\nend\n"},
+		{&ast.FuncDecl{Name: lit("f"), Recv: []ast.Expr{lit("-d"), lit("old")}, Body: &ast.BlockStmt{}}, "function f -d old\nend\n"},
+	} {
+		if got := ast.String(tt.d); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+
+	// nodes without a fish spelling are reported
+	var buf strings.Builder
+	err := ast.Fprint(&buf, &ast.ExprStmt{X: &ast.ParamExp{Var: name, DefaultValExp: &ast.DefaultValExp{Val: lit("x")}}})
+	if _, ok := err.(*ast.UnprintableError); !ok {
+		t.Errorf("Fprint of ${name:-x} returned %v, want an UnprintableError", err)
+	}
+	if err := ast.Fprint(&buf, &ast.ExprStmt{X: &ast.ProcSubst{Tok: token.GT, X: call("cat")}}); err == nil {
+		t.Error("Fprint of >(cat) succeeded")
+	}
+}
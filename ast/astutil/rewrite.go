@@ -0,0 +1,510 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package astutil contains common utilities for working with the fish AST.
+package astutil
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hulo-io/fishparser/ast"
+)
+
+// An ApplyFunc is invoked by Apply for each node n, even if n is nil,
+// before and/or after the node's children, using a Cursor describing
+// the current node and providing operations on it.
+//
+// The return value of ApplyFunc controls the syntax tree traversal.
+// See Apply for details.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses a syntax tree recursively, starting with root,
+// and calling pre and post for each node as described below.
+// Apply returns the syntax tree, possibly modified.
+//
+// If pre is not nil, it is called for each node before the node's
+// children are traversed (pre-order). If pre returns false, no
+// children are traversed, and post is not called for that node.
+//
+// If post is not nil, and a prior call of pre didn't return false,
+// post is called for each node after its children are traversed
+// (post-order). If post returns false, traversal is terminated and
+// Apply returns immediately.
+//
+// Only fields that refer to AST nodes are considered children;
+// i.e., token.Pos, strings, etc. are ignored. The sub-expressions
+// of a ParamExp, such as its DefaultValExp value, are children of
+// the ParamExp itself.
+//
+// Children are traversed in the order in which they appear in the
+// respective node's struct definition, except for the declarations and
+// statements of a File, which are traversed in source order, as given
+// by File.Nodes.
+func Apply(root ast.Node, pre, post ApplyFunc) (result ast.Node) {
+	parent := &struct{ ast.Node }{root}
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+		result = parent.Node
+	}()
+	a := &application{pre: pre, post: post}
+	a.apply(parent, "Node", nil, root)
+	return
+}
+
+var abort = new(int) // singleton, to signal termination of Apply
+
+// A Cursor describes a node encountered during Apply.
+// Information about the node and its parent is available
+// from the Node, Parent, Name, and Index methods.
+//
+// If p is a variable of type and value of the current parent node
+// c.Parent(), and f is the field identifier with name c.Name(),
+// the following invariants hold:
+//
+//	p.f            == c.Node()  if c.Index() <  0
+//	p.f[c.Index()] == c.Node()  if c.Index() >= 0
+//
+// The methods Replace, Delete, InsertBefore, and InsertAfter
+// can be used to change the AST without disrupting Apply.
+type Cursor struct {
+	parent ast.Node
+	name   string
+	iter   *iterator // valid if non-nil
+	node   ast.Node
+}
+
+// Node returns the current Node.
+func (c *Cursor) Node() ast.Node { return c.node }
+
+// Parent returns the parent of the current Node.
+func (c *Cursor) Parent() ast.Node { return c.parent }
+
+// Name returns the name of the parent Node field that contains the current Node.
+// Sub-expressions of a ParamExp are named after the path to them,
+// such as "DefaultValExp.Val".
+func (c *Cursor) Name() string { return c.name }
+
+// Index reports the index >= 0 of the current Node in the slice of Nodes that
+// contains it, or a value < 0 if the current Node is not part of a slice.
+// The index of the current node changes if InsertBefore is called while
+// processing the current node.
+func (c *Cursor) Index() int {
+	if c.iter != nil {
+		return c.iter.index
+	}
+	return -1
+}
+
+// field returns the current node's parent field value.
+func (c *Cursor) field() reflect.Value {
+	return fieldByName(c.parent, c.name)
+}
+
+// fieldByName returns the field of node with the given, possibly
+// dotted, name.
+func fieldByName(node ast.Node, name string) reflect.Value {
+	v := reflect.ValueOf(node)
+	for _, f := range strings.Split(name, ".") {
+		v = reflect.Indirect(v).FieldByName(f)
+	}
+	return v
+}
+
+// Replace replaces the current Node with n.
+// The replacement node is not walked by Apply.
+func (c *Cursor) Replace(n ast.Node) {
+	v := c.field()
+	if i := c.Index(); i >= 0 {
+		v = v.Index(i)
+	}
+	v.Set(reflect.ValueOf(n))
+}
+
+// Delete deletes the current Node from its containing slice.
+// If the current Node is not part of a slice, Delete panics.
+func (c *Cursor) Delete() {
+	i := c.Index()
+	if i < 0 {
+		panic("Delete node not contained in slice")
+	}
+	v := c.field()
+	l := v.Len()
+	reflect.Copy(v.Slice(i, l), v.Slice(i+1, l))
+	v.Index(l - 1).Set(reflect.Zero(v.Type().Elem()))
+	v.SetLen(l - 1)
+	c.iter.step--
+}
+
+// InsertAfter inserts n after the current Node in its containing slice.
+// If the current Node is not part of a slice, InsertAfter panics.
+// Apply does not walk n.
+func (c *Cursor) InsertAfter(n ast.Node) {
+	i := c.Index()
+	if i < 0 {
+		panic("InsertAfter node not contained in slice")
+	}
+	v := c.field()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	l := v.Len()
+	reflect.Copy(v.Slice(i+2, l), v.Slice(i+1, l))
+	v.Index(i + 1).Set(reflect.ValueOf(n))
+	c.iter.step++
+}
+
+// InsertBefore inserts n before the current Node in its containing slice.
+// If the current Node is not part of a slice, InsertBefore panics.
+// Apply will not walk n.
+func (c *Cursor) InsertBefore(n ast.Node) {
+	i := c.Index()
+	if i < 0 {
+		panic("InsertBefore node not contained in slice")
+	}
+	v := c.field()
+	v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+	l := v.Len()
+	reflect.Copy(v.Slice(i+1, l), v.Slice(i, l))
+	v.Index(i).Set(reflect.ValueOf(n))
+	c.iter.index++
+}
+
+// application carries all the shared data so we can pass it around cheaply.
+type application struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+	iter      iterator
+}
+
+func (a *application) apply(parent ast.Node, name string, iter *iterator, n ast.Node) {
+	// convert typed nil into untyped nil
+	if isNil(n) {
+		n = nil
+	}
+
+	// avoid heap-allocating a new cursor for each apply call; reuse a.cursor instead
+	saved := a.cursor
+	a.cursor.parent = parent
+	a.cursor.name = name
+	a.cursor.iter = iter
+	a.cursor.node = n
+
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+
+	// walk children
+	// (the order of the cases matches the order of the corresponding node types in ast.go)
+	switch n := n.(type) {
+	case nil:
+		// nothing to do
+
+	// Comments
+	case *ast.Comment:
+		// nothing to do
+
+	case *ast.CommentGroup:
+		a.applyList(n, "List")
+
+	// Declarations
+	case *ast.FuncDecl:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "Description", nil, n.Description)
+		a.applyList(n, "ArgNames")
+		a.applyList(n, "Wraps")
+		a.applyList(n, "OnEvent")
+		a.applyList(n, "OnVariable")
+		a.applyList(n, "OnSignal")
+		a.applyList(n, "OnJobExit")
+		a.applyList(n, "OnProcessExit")
+		a.applyList(n, "InheritVariable")
+		a.apply(n, "NoScopeShadowing", nil, n.NoScopeShadowing)
+		a.applyList(n, "Args")
+		a.apply(n, "Body", nil, n.Body)
+		a.applyList(n, "Recv")
+
+	case *ast.FuncOpt:
+		a.apply(n, "Value", nil, n.Value)
+		a.applyList(n, "Names")
+
+	// Statements
+	case *ast.BadStmt:
+		// nothing to do
+
+	case *ast.AssignStmt:
+		a.apply(n, "Lhs", nil, n.Lhs)
+		a.apply(n, "Rhs", nil, n.Rhs)
+
+	case *ast.SetStmt:
+		a.applyList(n, "Vars")
+		a.applyList(n, "Values")
+		a.applyList(n, "Redirs")
+
+	case *ast.BlockStmt:
+		a.applyList(n, "List")
+
+	case *ast.ExprStmt:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.DeclStmt:
+		a.apply(n, "Decl", nil, n.Decl)
+
+	case *ast.ReturnStmt:
+		a.apply(n, "X", nil, n.X)
+		a.applyList(n, "Redirs")
+
+	case *ast.ExitStmt:
+		a.apply(n, "X", nil, n.X)
+		a.applyList(n, "Redirs")
+
+	case *ast.BreakStmt, *ast.ContinueStmt:
+		// nothing to do
+
+	case *ast.BeginStmt:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Body", nil, n.Body)
+		a.applyList(n, "Redirs")
+
+	case *ast.WhileStmt:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Cond", nil, n.Cond)
+		a.apply(n, "Body", nil, n.Body)
+		a.applyList(n, "Redirs")
+
+	case *ast.ForeachStmt:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Elem", nil, n.Elem)
+		a.applyList(n, "Group")
+		a.apply(n, "Body", nil, n.Body)
+		a.applyList(n, "Redirs")
+
+	case *ast.IfStmt:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Cond", nil, n.Cond)
+		a.apply(n, "Body", nil, n.Body)
+		a.applyList(n, "Elif")
+		a.apply(n, "Else", nil, n.Else)
+		a.applyList(n, "Redirs")
+
+	case *ast.ElseIfClause:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Cond", nil, n.Cond)
+		a.apply(n, "Body", nil, n.Body)
+
+	case *ast.ElseClause:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Body", nil, n.Body)
+
+	case *ast.SwitchStmt:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.apply(n, "Value", nil, n.Value)
+		a.applyList(n, "Cases")
+		a.applyList(n, "Redirs")
+
+	case *ast.CaseClause:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.applyList(n, "Patterns")
+		a.apply(n, "Body", nil, n.Body)
+
+	// Expressions
+	case *ast.BadExpr, *ast.Ident, *ast.BasicLit:
+		// nothing to do
+
+	case *ast.BinaryExpr:
+		a.apply(n, "X", nil, n.X)
+		a.apply(n, "Y", nil, n.Y)
+
+	case *ast.CallExpr:
+		a.apply(n, "Func", nil, n.Func)
+		a.applyList(n, "Recv")
+		a.applyList(n, "Redirs")
+
+	case *ast.StatusCall:
+		a.apply(n, "Sub", nil, n.Sub)
+		a.applyList(n, "Args")
+		a.applyList(n, "Redirs")
+
+	case *ast.Redirect:
+		a.apply(n, "Word", nil, n.Word)
+
+	case *ast.Word:
+		a.applyList(n, "Parts")
+
+	case *ast.Lit, *ast.Glob, *ast.SingleQuoted:
+		// nothing to do
+
+	case *ast.DoubleQuoted:
+		a.applyList(n, "Parts")
+
+	case *ast.BraceExp:
+		a.applyList(n, "Elems")
+
+	case *ast.VarRef:
+		a.apply(n, "Name", nil, n.Name)
+		a.apply(n, "Index", nil, n.Index)
+
+	case *ast.VarExpansion:
+		a.apply(n, "X", nil, n.X)
+		a.applyList(n, "Index")
+
+	case *ast.IndexExpr:
+		a.applyList(n, "List")
+
+	case *ast.RangeExpr:
+		a.apply(n, "Low", nil, n.Low)
+		a.apply(n, "High", nil, n.High)
+
+	case *ast.Pipeline:
+		a.applyList(n, "Cmds")
+		a.applyList(n, "Pipes")
+
+	case *ast.Pipe:
+		// nothing to do
+
+	case *ast.Job:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.JobConjunction:
+		a.applyList(n, "Jobs")
+
+	case *ast.StmtExpr:
+		a.apply(n, "Stmt", nil, n.Stmt)
+
+	case *ast.JobList:
+		a.applyList(n, "List")
+
+	case *ast.BasicTestExpr:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.ExtendedTestExpr:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.ArithEvalExpr:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.CmdGroup:
+		a.applyList(n, "List")
+
+	case *ast.CmdSubst:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.ProcSubst:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.ArithExp:
+		a.apply(n, "X", nil, n.X)
+
+	case *ast.ParamExp:
+		a.apply(n, "Var", nil, n.Var)
+		switch {
+		case n.DefaultValExp != nil:
+			a.apply(n, "DefaultValExp.Val", nil, n.DefaultValExp.Val)
+		case n.DefaultValAssignExp != nil:
+			a.apply(n, "DefaultValAssignExp.Val", nil, n.DefaultValAssignExp.Val)
+		case n.NonNullCheckExp != nil:
+			a.apply(n, "NonNullCheckExp.Val", nil, n.NonNullCheckExp.Val)
+		case n.NonNullExp != nil:
+			a.apply(n, "NonNullExp.Val", nil, n.NonNullExp.Val)
+		case n.DelPrefix != nil:
+			a.apply(n, "DelPrefix.Val", nil, n.DelPrefix.Val)
+		case n.DelSuffix != nil:
+			a.apply(n, "DelSuffix.Val", nil, n.DelSuffix.Val)
+		}
+
+	// Files
+	case *ast.File:
+		a.apply(n, "Doc", nil, n.Doc)
+		a.applyFile(n)
+		// Don't walk n.Comments; they have either been walked already if
+		// they are Doc comments, or they can be easily walked explicitly.
+
+	default:
+		panic(fmt.Sprintf("Apply: unexpected node type %T", n))
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(abort)
+	}
+
+	a.cursor = saved
+}
+
+// applyFile applies to the declarations and statements of f in source
+// order, using the rule of ast.File.Nodes to interleave them.
+func (a *application) applyFile(f *ast.File) {
+	saved := a.iter
+	var decls, stmts iterator
+	for {
+		// must reload f.Decls and f.Stmts each time, since cursor modifications might change them
+		var name string
+		var x ast.Node
+		switch {
+		case decls.index < len(f.Decls) && (stmts.index >= len(f.Stmts) || !before(f.Stmts[stmts.index], f.Decls[decls.index])):
+			name, x, a.iter = "Decls", f.Decls[decls.index], decls
+		case stmts.index < len(f.Stmts):
+			name, x, a.iter = "Stmts", f.Stmts[stmts.index], stmts
+		default:
+			a.iter = saved
+			return
+		}
+
+		a.iter.step = 1
+		a.apply(f, name, &a.iter, x)
+		a.iter.index += a.iter.step
+		if name == "Decls" {
+			decls = a.iter
+		} else {
+			stmts = a.iter
+		}
+	}
+}
+
+// before reports whether the statement s comes before the declaration
+// d in their file. Both must have a position to be ordered.
+func before(s ast.Stmt, d ast.Decl) bool {
+	if isNil(s) || isNil(d) {
+		return false
+	}
+	return s.Pos().IsValid() && d.Pos().IsValid() && s.Pos() < d.Pos()
+}
+
+// isNil reports whether n is nil or a typed nil, as an element of a
+// slice of a bad AST may be.
+func isNil(n ast.Node) bool {
+	v := reflect.ValueOf(n)
+	return !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// An iterator controls iteration over a slice of nodes.
+type iterator struct {
+	index, step int
+}
+
+func (a *application) applyList(parent ast.Node, name string) {
+	// avoid heap-allocating a new iterator for each applyList call; reuse a.iter instead
+	saved := a.iter
+	a.iter.index = 0
+	for {
+		// must reload parent.name each time, since cursor modifications might change it
+		v := fieldByName(parent, name)
+		if a.iter.index >= v.Len() {
+			break
+		}
+
+		// element x may be nil in a bad AST - be cautious
+		var x ast.Node
+		if e := v.Index(a.iter.index); e.IsValid() {
+			x, _ = e.Interface().(ast.Node)
+		}
+
+		a.iter.step = 1
+		a.apply(parent, name, &a.iter, x)
+		a.iter.index += a.iter.step
+	}
+	a.iter = saved
+}
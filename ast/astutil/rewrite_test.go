@@ -0,0 +1,170 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package astutil_test
+
+import (
+	"testing"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/ast/astutil"
+	"github.com/hulo-io/fishparser/parser"
+	"github.com/hulo-io/fishparser/token"
+)
+
+const src = `function setup
+    echo setting up
+    rm -rf build
+end
+
+for d in src docs
+    echo $d
+end
+`
+
+func call(name string, args ...string) *ast.ExprStmt {
+	x := &ast.CallExpr{Func: &ast.Ident{Name: name}}
+	for _, a := range args {
+		x.Recv = append(x.Recv, &ast.Ident{Name: a})
+	}
+	return &ast.ExprStmt{X: x}
+}
+
+func commands(list []ast.Stmt) (names []string) {
+	for _, s := range list {
+		names = append(names, s.(*ast.ExprStmt).X.(*ast.CallExpr).Func.Name)
+	}
+	return
+}
+
+func TestApply(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "setup.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var visited []string
+	astutil.Apply(f, func(c *astutil.Cursor) bool {
+		if _, ok := c.Parent().(*ast.BlockStmt); !ok || c.Name() != "List" {
+			return true
+		}
+		stmt, ok := c.Node().(*ast.ExprStmt)
+		if !ok {
+			return true
+		}
+		switch stmt.X.(*ast.CallExpr).Func.Name {
+		case "rm":
+			c.Delete()
+		case "echo":
+			visited = append(visited, "echo")
+			c.InsertBefore(call("set", "-l", "step"))
+			c.InsertAfter(call("true"))
+			c.Replace(call("printf", "%s\\n"))
+		}
+		return true
+	}, nil)
+
+	if len(visited) != 2 {
+		t.Errorf("visited %d echo statements, want 2", len(visited))
+	}
+
+	fn := f.Decls[0].(*ast.FuncDecl)
+	want := []string{"set", "printf", "true"}
+	if got := commands(fn.Body.List); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("function body runs %v, want %v", got, want)
+	}
+	loop := f.Stmts[0].(*ast.ForeachStmt)
+	if got := commands(loop.Body.List); len(got) != len(want) || got[1] != "printf" {
+		t.Errorf("loop body runs %v, want %v", got, want)
+	}
+}
+
+func TestApplyCursor(t *testing.T) {
+	val := &ast.Ident{Name: "guest"}
+	x := &ast.ParamExp{
+		Var:           &ast.Ident{Name: "USER"},
+		DefaultValExp: &ast.DefaultValExp{Val: val},
+	}
+	f := &ast.File{Stmts: []ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{
+		Func: &ast.Ident{Name: "echo"},
+		Recv: []ast.Expr{&ast.Ident{Name: "hello"}, x},
+	}}}}
+
+	type visit struct {
+		name  string
+		index int
+	}
+	var got []visit
+	astutil.Apply(f, func(c *astutil.Cursor) bool {
+		if c.Node() == nil {
+			return false
+		}
+		got = append(got, visit{c.Name(), c.Index()})
+		if c.Node() == val {
+			if c.Parent() != x {
+				t.Errorf("parent of default value is %T", c.Parent())
+			}
+			c.Replace(&ast.Ident{Name: "nobody"})
+		}
+		return true
+	}, nil)
+
+	want := []visit{
+		{"Node", -1}, {"Stmts", 0}, {"X", -1}, {"Func", -1},
+		{"Recv", 0}, {"Recv", 1}, {"Var", -1}, {"DefaultValExp.Val", -1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got visits %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("visit %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	if name := x.DefaultValExp.Val.(*ast.Ident).Name; name != "nobody" {
+		t.Errorf("default value is %s after Replace", name)
+	}
+
+	// a false result from post stops the traversal
+	var idents int
+	astutil.Apply(f, nil, func(c *astutil.Cursor) bool {
+		_, isIdent := c.Node().(*ast.Ident)
+		if isIdent {
+			idents++
+		}
+		return !isIdent
+	})
+	if idents != 1 {
+		t.Errorf("post saw %d identifiers, want 1", idents)
+	}
+}
+
+func TestApplyOrder(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "order.fish", "echo a; function f; end; echo b", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	astutil.Apply(f, func(c *astutil.Cursor) bool {
+		if c.Parent() != f {
+			return true
+		}
+		switch n := c.Node().(type) {
+		case *ast.ExprStmt:
+			order = append(order, ast.ExprStr(n.X))
+			c.InsertAfter(call("true"))
+		case *ast.FuncDecl:
+			order = append(order, "function "+n.Name.Name)
+		}
+		return true
+	}, nil)
+
+	want := []string{"echo a", "function f", "echo b"}
+	if len(order) != len(want) || order[0] != want[0] || order[1] != want[1] || order[2] != want[2] {
+		t.Errorf("visited %q, want %q", order, want)
+	}
+	if got := commands(f.Stmts); len(got) != 4 || got[1] != "true" || got[3] != "true" {
+		t.Errorf("file runs %v, want echo, true, echo, true", got)
+	}
+}
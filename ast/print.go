@@ -4,298 +4,1322 @@
 package ast
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/hulo-io/fishparser/quote"
 	"github.com/hulo-io/fishparser/token"
 )
 
-var _ Visitor = (*printer)(nil)
+// An UnprintableError reports a node that has no spelling in fish,
+// such as a process substitution that is written to.
+type UnprintableError struct {
+	Node   Node
+	Reason string
+}
+
+func (e *UnprintableError) Error() string {
+	return fmt.Sprintf("cannot print %T as fish: %s", e.Node, e.Reason)
+}
+
+// A PrintConfig controls the layout of the fish source printed by
+// PrintConfig.Fprint. The zero PrintConfig lays it out the way
+// fish_indent does by default. The printer package offers the same
+// layout with a Config of its own.
+type PrintConfig struct {
+	Indent         int  // width of an indentation level in columns; 4 if zero
+	UseTabs        bool // indent with a tab per level instead of spaces
+	MaxWidth       int  // break longer commands with "\" continuations; no limit if zero
+	DropComments   bool // do not print comments
+	DropBlankLines bool // do not keep the blank lines between statements
+}
 
+// A printer prints a syntax tree as fish source, laid out the way
+// fish_indent lays it out. Nodes without a fish spelling are left
+// out; the first of them is kept in err.
 type printer struct {
-	output io.Writer
-	ident  string
-	temp   string
+	PrintConfig
+	fset     *token.FileSet
+	output   bytes.Buffer
+	indent   int             // current indentation level
+	comments []*CommentGroup // comments not yet printed, in source order
+	useDocs  bool            // print Doc fields rather than comments
+	lastLine int             // source line of the last printed line; 0 if unknown
+	err      error           // first unprintable node, if any
+
+	// a block statement run by a job shares its first and last
+	// lines with the rest of the job
+	lead       []string // words leading the next line
+	outdent    bool     // print the next line one level shallower
+	carry      bool     // keep the "end" line at carryLevel in endLine
+	carryLevel int
+	endLine    []string
+	blockEnd   token.Pos // comments from here on follow the rest of the job
+}
+
+func (p *printer) init(cfg *PrintConfig, fset *token.FileSet, node Node) {
+	p.PrintConfig = *cfg
+	if p.Indent <= 0 {
+		p.Indent = 4 // as fish_indent indents
+	}
+	p.fset = fset
+	if p.DropComments {
+		return
+	}
+	if f, ok := node.(*File); ok && fset != nil && f.Comments != nil {
+		p.comments = f.Comments
+		return
+	}
+	// without the comments of a parsed file, the doc comments
+	// are the only ones whose place is known
+	p.useDocs = true
 }
 
-func (p *printer) print(toks ...any) *printer {
-	if len(p.ident) != 0 {
-		toks = append([]any{p.ident}, toks...)
+// unprintable records that n cannot be printed as fish.
+func (p *printer) unprintable(n Node, reason string) {
+	if p.err == nil {
+		p.err = &UnprintableError{Node: n, Reason: reason}
 	}
-	fmt.Fprint(p.output, toks...)
-	return p
 }
 
-// println prints tokens with new line
-func (p *printer) println(toks ...any) *printer {
-	if len(p.ident) != 0 {
-		toks = append([]any{p.ident}, toks...)
+// lineOf returns the source line of pos, or 0 if it is not known.
+func (p *printer) lineOf(pos token.Pos) int {
+	if p.fset == nil || !pos.IsValid() {
+		return 0
 	}
-	fmt.Fprintln(p.output, toks...)
-	return p
+	return p.fset.Position(pos).Line
 }
 
-// println_c prints tokens with new line and compressing
-func (p *printer) println_c(toks ...string) *printer {
-	if len(p.ident) != 0 {
-		toks = append([]string{p.ident}, toks...)
+// indentation returns the indentation for the given level
+// and its width in columns.
+func (p *printer) indentation(level int) (string, int) {
+	if p.UseTabs {
+		return strings.Repeat("\t", level), level * p.Indent
+	}
+	return strings.Repeat(" ", level*p.Indent), level * p.Indent
+}
+
+// line prints words as one line at the current indentation. The words
+// are taken from the source between pos and end. A comment following
+// end on the same source line is appended to the line.
+//
+// If the line is wider than MaxWidth, it is broken before the word
+// that does not fit and continued one level deeper. A word after the
+// first that starts with "#" is a comment following an operator, as
+// in "a | # c"; the line is broken after it, and continued the same way.
+func (p *printer) line(pos, end token.Pos, words ...string) {
+	if l := p.lineOf(pos); l > 0 {
+		if p.lastLine > 0 && l > p.lastLine+1 && !p.DropBlankLines {
+			// keep one blank line, as fish_indent does
+			p.output.WriteByte('\n')
+		}
+	}
+
+	if p.lead != nil {
+		words = append(p.lead, words...)
+		p.lead = nil
+	}
+	level := p.indent
+	if p.outdent {
+		level--
+		p.outdent = false
+	}
+
+	indent, col := p.indentation(level)
+	p.output.WriteString(indent)
+	first := true
+	for i, w := range words {
+		if w == "" {
+			continue // left out, such as an unprintable node
+		}
+		if i > 0 && strings.HasPrefix(w, "#") {
+			if !first {
+				p.output.WriteByte(' ')
+			}
+			p.output.WriteString(w + "\n")
+			indent, col = p.indentation(level + 1)
+			p.output.WriteString(indent)
+			first = true
+			continue
+		}
+		n := utf8.RuneCountInString(w)
+		if !first {
+			if p.MaxWidth > 0 && col+1+n > p.MaxWidth {
+				indent, col = p.indentation(level + 1)
+				p.output.WriteString(" \\\n")
+				p.output.WriteString(indent)
+			} else {
+				p.output.WriteByte(' ')
+				col++
+			}
+		}
+		p.output.WriteString(w)
+		col += n
+		first = false
 	}
-	result := ""
-	for _, tok := range toks {
-		result += tok
+
+	if l := p.lineOf(end); l > 0 {
+		if len(p.comments) > 0 && p.lineOf(p.comments[0].Pos()) == l && p.comments[0].Pos() >= end &&
+			(!p.blockEnd.IsValid() || p.comments[0].Pos() < p.blockEnd) {
+			p.output.WriteString(" " + p.comments[0].List[0].Text)
+			p.comments = p.comments[1:]
+		}
+		p.lastLine = l
 	}
-	fmt.Fprintln(p.output, result)
-	return p
+	p.output.WriteByte('\n')
 }
 
-func (p *printer) block(stmts []Stmt) *printer {
-	for _, s := range stmts {
-		Walk(p, s)
+// flush prints the comments that start before pos, each on its own line.
+// An invalid pos flushes all remaining comments.
+func (p *printer) flush(pos token.Pos) {
+	for len(p.comments) > 0 && (!pos.IsValid() || p.comments[0].Pos() < pos) {
+		g := p.comments[0]
+		p.comments = p.comments[1:]
+		for _, c := range g.List {
+			p.line(c.Pos(), token.NoPos, c.Text)
+			p.lastLine = p.lineOf(c.Pos())
+		}
 	}
-	return p
 }
 
-func (p *printer) Visit(node Node) Visitor {
-	switch n := node.(type) {
-	case *File:
-		for _, d := range n.Decls {
-			Walk(p, d)
+// doc prints the doc comment g if comments are printed from Doc fields.
+func (p *printer) doc(g *CommentGroup) {
+	// a statement run by a job has no line of its own to put it on
+	if p.useDocs && g != nil && p.lead == nil {
+		for _, c := range g.List {
+			p.line(token.NoPos, token.NoPos, c.Text)
 		}
+	}
+}
 
-		for _, s := range n.Stmts {
-			Walk(p, s)
+// words appends to w the words of the job x that a line may be broken
+// between: the command names, their arguments and redirections, and
+// the operators joining the commands.
+//
+// A block statement run by the job is printed when it is reached, with
+// w leading its first line. The words of its "end" line are returned
+// to lead the line printing the rest of the job.
+func (p *printer) words(w []string, x Expr) []string {
+	switch x := x.(type) {
+	case nil:
+		return w
+
+	case *CallExpr:
+		w = append(w, p.expr(x.Func))
+		for _, arg := range x.Recv {
+			w = append(w, p.expr(arg))
 		}
+		for _, r := range x.Redirs {
+			w = append(w, p.expr(r))
+		}
+		return w
 
-	case *FuncDecl:
-		p.println(token.FUNCTION, ExprStr(n.Name), ExprListStr(n.Recv))
-		temp := p.ident
-		p.ident += "  "
-		p.block(n.Body.List)
-		p.ident = temp
-		println(token.END)
+	case *StatusCall:
+		w = append(w, "status")
+		if x.Sub != nil {
+			w = append(w, x.Sub.Name)
+		}
+		for _, arg := range x.Args {
+			w = append(w, p.expr(arg))
+		}
+		for _, r := range x.Redirs {
+			w = append(w, p.expr(r))
+		}
+		return w
+
+	case *StmtExpr:
+		switch s := x.Stmt.(type) {
+		case *ReturnStmt:
+			return p.exit(w, token.RETURN, s.X, s.Redirs)
+		case *ExitStmt:
+			return p.exit(w, "exit", s.X, s.Redirs)
+		case *BreakStmt:
+			return append(w, token.BREAK)
+		case *ContinueStmt:
+			return append(w, token.CONTINUE)
+		}
+		p.flush(x.Pos())
+		lead, carry, carryLevel, blockEnd := p.lead, p.carry, p.carryLevel, p.blockEnd
+		p.lead, p.carry, p.carryLevel, p.blockEnd = w, true, p.indent, x.End()
+		p.stmt(x.Stmt)
+		w = p.endLine
+		p.lead, p.carry, p.carryLevel, p.blockEnd, p.endLine = lead, carry, carryLevel, blockEnd, nil
+		return w
+
+	case *Pipeline:
+		w = p.words(w, x.Cmds[0])
+		for i, cmd := range x.Cmds[1:] {
+			if i < len(x.Pipes) {
+				w = append(w, pipe(x.Pipes[i]))
+			} else {
+				w = append(w, token.BITOR)
+			}
+			w = p.words(p.opComments(w, cmd), cmd)
+		}
+		return w
+
+	case *Job:
+		w = p.words(append(w, string(x.NotTok)), x.X)
+		if x.Amp.IsValid() {
+			w = append(w, token.BITAND)
+		}
+		return w
+
+	case *JobConjunction:
+		w = p.words(append(w, string(x.DecTok)), x.Jobs[0])
+		for i, job := range x.Jobs[1:] {
+			op := token.Token(token.AND)
+			if i < len(x.Ops) {
+				op = x.Ops[i]
+			}
+			w = p.words(p.opComments(append(w, string(op)), job), job)
+		}
+		return w
+
+	case *BinaryExpr:
+		if x.Compress {
+			break
+		}
+		switch op := string(x.Op); {
+		case op == token.NONE:
+			// a prefix such as "not" or "and"
+			return p.words(p.words(w, x.X), x.Y)
+		case op == token.AND || op == token.OR || strings.HasSuffix(op, token.BITOR):
+			return p.words(p.opComments(append(p.words(w, x.X), op), x.Y), x.Y)
+		default:
+			// a redirection keeps its target
+			return append(p.words(w, x.X), op+p.expr(x.Y))
+		}
+	}
+	return append(w, p.expr(x))
+}
+
+// opComments appends to w the comments before the command x, which
+// follow an operator whose job goes on with x on a later line. A block
+// statement starting x prints them before its first line instead.
+func (p *printer) opComments(w []string, x Expr) []string {
+	if startsBlock(x) {
+		return w
+	}
+	pos := x.Pos()
+	for len(p.comments) > 0 && pos.IsValid() && p.comments[0].Pos() < pos {
+		for _, c := range p.comments[0].List {
+			w = append(w, c.Text)
+		}
+		p.comments = p.comments[1:]
+	}
+	return w
+}
+
+// startsBlock reports whether the job x starts with a block statement.
+func startsBlock(x Expr) bool {
+	for {
+		switch y := x.(type) {
+		case *Job:
+			x = y.X
+		case *Pipeline:
+			x = y.Cmds[0]
+		case *JobConjunction:
+			x = y.Jobs[0]
+		case *BinaryExpr:
+			x = y.X
+		case *StmtExpr:
+			switch y.Stmt.(type) {
+			case *ReturnStmt, *ExitStmt, *BreakStmt, *ContinueStmt:
+				return false
+			}
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// exit appends to w the words of a return or exit statement: the
+// keyword name, the status x and the redirections.
+func (p *printer) exit(w []string, name string, x Expr, redirs []*Redirect) []string {
+	w = p.words(append(w, name), x)
+	for _, r := range redirs {
+		w = append(w, p.expr(r))
+	}
+	return w
+}
+
+// block prints the statements of list one level deeper. Comments
+// before until belong to the block.
+func (p *printer) block(list []Stmt, until token.Pos) {
+	p.indent++
+	for _, s := range list {
+		p.stmt(s)
+	}
+	if until.IsValid() {
+		p.flush(until)
+	}
+	p.indent--
+}
+
+// body returns the statements of b, if any.
+func body(b *BlockStmt) []Stmt {
+	if b == nil {
+		return nil
+	}
+	return b.List
+}
+
+// after returns the position n bytes after pos, or NoPos if pos is
+// not known.
+func after(pos token.Pos, n int) token.Pos {
+	if !pos.IsValid() {
+		return token.NoPos
+	}
+	return pos + token.Pos(n)
+}
+
+// end prints the "end" at pos closing a block, followed by the
+// redirections of the block.
+func (p *printer) end(pos token.Pos, redirs []*Redirect) {
+	words := []string{token.END}
+	end := after(pos, len(token.END))
+	for _, r := range redirs {
+		words = append(words, p.expr(r))
+		end = r.End()
+	}
+	if p.carry && p.indent == p.carryLevel {
+		p.carry, p.endLine = false, words
+		return
+	}
+	p.line(pos, end, words...)
+}
+
+func (p *printer) stmt(s Stmt) {
+	p.flush(s.Pos())
+
+	switch s := s.(type) {
+	case *BadStmt:
+		p.unprintable(s, "statement contains syntax errors")
+
+	case *DeclStmt:
+		p.decl(s.Decl)
+
+	case *ExprStmt:
+		if g, ok := s.X.(*CmdGroup); ok && g.Lbrace.IsValid() {
+			p.line(g.Lbrace, after(g.Lbrace, 1), token.BEGIN)
+			p.block(g.List, g.Rbrace)
+			p.line(g.Rbrace, after(g.Rbrace, 1), token.END)
+			break
+		}
+		p.line(s.Pos(), s.End(), p.words(nil, s.X)...)
+
+	case *SetStmt:
+		words := append([]string{"set"}, s.Options()...)
+		for _, v := range s.Vars {
+			words = append(words, p.expr(v))
+		}
+		for _, x := range s.Values {
+			words = append(words, p.expr(x))
+		}
+		for _, r := range s.Redirs {
+			words = append(words, p.expr(r))
+		}
+		p.line(s.Pos(), s.End(), words...)
 
 	case *AssignStmt:
-		if n.Local {
-			p.println_c(token.LOCAL, token.SPACE, ExprStr(n.Lhs), token.ASSIGN, ExprStr(n.Rhs))
-		} else {
-			p.println_c(ExprStr(n.Lhs), token.ASSIGN, ExprStr(n.Rhs))
+		// fish has no name=value statement; set does the same job
+		words := []string{"set"}
+		if s.Local {
+			words = append(words, "-l")
 		}
+		words = append(words, p.expr(s.Lhs))
+		p.line(s.Pos(), s.End(), p.words(words, s.Rhs)...)
 
 	case *ReturnStmt:
-		p.println(token.RETURN, ExprStr(n.X))
+		p.line(s.Pos(), s.End(), p.exit(nil, token.RETURN, s.X, s.Redirs)...)
+
+	case *ExitStmt:
+		p.line(s.Pos(), s.End(), p.exit(nil, "exit", s.X, s.Redirs)...)
 
 	case *BreakStmt:
-		p.println(token.BREAK)
+		p.line(s.Pos(), s.End(), token.BREAK)
 
 	case *ContinueStmt:
-		p.println(token.CONTINUE)
+		p.line(s.Pos(), s.End(), token.CONTINUE)
 
-	case *WhileStmt:
-		p.println(token.WHILE, ExprStr(n.Cond))
+	case *BlockStmt:
+		p.line(s.Opening, after(s.Opening, 1), token.BEGIN)
+		p.block(s.List, s.Closing)
+		p.line(s.Closing, after(s.Closing, 1), token.END)
 
-		temp := p.ident
-		p.ident += "  "
-		p.block(n.Body.List)
-		p.ident = temp
+	case *BeginStmt:
+		p.doc(s.Doc)
+		p.line(s.Begin, after(s.Begin, len(token.BEGIN)), token.BEGIN)
+		p.block(body(s.Body), s.EndPos)
+		p.end(s.EndPos, s.Redirs)
 
-		p.println(token.END)
+	case *WhileStmt:
+		p.doc(s.Doc)
+		p.cond(s, s.While, s.Cond, token.WHILE)
+		p.block(body(s.Body), s.EndPos)
+		p.end(s.EndPos, s.Redirs)
 
 	case *ForeachStmt:
-		p.println(token.FOR, ExprStr(n.Elem), token.IN, ExprListStr(n.Group))
-
-		temp := p.ident
-		p.ident += "  "
-		p.block(n.Body.List)
-		p.ident = temp
-
-		p.println(token.END)
+		p.doc(s.Doc)
+		words := []string{token.FOR, p.expr(s.Elem), token.IN}
+		end := after(s.In, len(token.IN))
+		for _, x := range s.Group {
+			words = append(words, p.expr(x))
+			end = x.End()
+		}
+		p.line(s.For, end, words...)
+		p.block(body(s.Body), s.EndPos)
+		p.end(s.EndPos, s.Redirs)
 
 	case *IfStmt:
-		p.println(token.IF, ExprStr(n.Cond))
+		p.doc(s.Doc)
+		p.cond(s, s.If, s.Cond, token.IF)
+		// each branch runs until the "else" of the next one
+		var branches []Stmt
+		for _, c := range s.Elif {
+			branches = append(branches, c)
+		}
+		if s.Else != nil {
+			branches = append(branches, s.Else)
+		}
+		next := s.EndPos
+		if len(branches) > 0 {
+			next = branchPos(branches[0])
+		}
+		p.block(body(s.Body), next)
+
+		for i, c := range branches {
+			next := s.EndPos
+			if i+1 < len(branches) {
+				next = branchPos(branches[i+1])
+			}
+			p.elseClause(c, next)
+		}
+		p.end(s.EndPos, s.Redirs)
 
-		temp := p.ident
-		p.ident += "  "
-		p.block(n.Body.List)
-		p.ident = temp
+	case *ElseIfClause:
+		p.elseClause(s, token.NoPos)
 
-		for _, elif := range n.Elif {
-			p.println(token.ELSE, token.IF, ExprStr(elif.Cond))
+	case *ElseClause:
+		p.elseClause(s, token.NoPos)
 
-			temp := p.ident
-			p.ident += "  "
-			p.block(n.Body.List)
-			p.ident = temp
+	case *SwitchStmt:
+		p.doc(s.Doc)
+		if s.Value == nil {
+			p.unprintable(s, "switch statement has no value")
+			p.line(s.Switch, after(s.Switch, len(token.SWITCH)), token.SWITCH)
+		} else {
+			p.line(s.Switch, s.Value.End(), token.SWITCH, p.expr(s.Value))
 		}
-
-		if n.Else != nil {
-			p.println(token.ELSE)
-			temp := p.ident
-			p.ident += "  "
-			p.block(n.Body.List)
-			p.ident = temp
+		p.indent++
+		for i, c := range s.Cases {
+			next := s.EndPos
+			if i+1 < len(s.Cases) {
+				next = s.Cases[i+1].Case
+			}
+			p.caseClause(c, next)
 		}
+		p.indent--
+		p.end(s.EndPos, s.Redirs)
 
-		p.println(token.END)
+	case *CaseClause:
+		p.caseClause(s, token.NoPos)
 
-	case *SwitchStmt:
-		p.println(token.SWITCH, token.LPAREN+ExprStr(n.Var)+token.RPAREN)
+	default:
+		p.unprintable(s, "unknown statement")
+	}
+}
 
-		for _, c := range n.Cases {
-			cs := []string{}
-			for _, cond := range c.Conds {
-				cs = append(cs, ExprStr(cond))
-			}
+// cond prints the keywords kw at pos followed by the condition x of the
+// if or while statement n. The lines after the first go one level
+// deeper, as fish_indent puts them: the jobs continuing a job list, and
+// the lines of a block the condition runs, its "end" included.
+func (p *printer) cond(n Stmt, pos token.Pos, x Expr, kw ...string) {
+	list, ok := x.(*JobList)
+	if x == nil || ok && len(list.List) == 0 {
+		p.unprintable(n, "condition is missing")
+		p.line(pos, token.NoPos, kw...)
+		return
+	}
+	p.indent++
+	p.outdent = true
+	if !ok {
+		p.line(pos, x.End(), p.words(kw, x)...)
+		p.indent--
+		return
+	}
+	switch s := list.List[0].(type) {
+	case *BeginStmt:
+		p.line(pos, after(s.Begin, len(token.BEGIN)), append(kw, token.BEGIN)...)
+		p.block(body(s.Body), s.EndPos)
+		p.end(s.EndPos, s.Redirs)
+	case *ExprStmt:
+		p.line(pos, s.End(), p.words(kw, s.X)...)
+	default:
+		p.line(pos, s.End(), append(kw, p.inline(s))...)
+	}
+	for _, s := range list.List[1:] {
+		p.stmt(s)
+	}
+	p.indent--
+}
+
+// branchPos returns the position of the "else if" or "else" branch c
+// of an if statement, including its doc comment, which is printed at
+// the level of the "else" rather than in the body of the previous
+// branch.
+func branchPos(c Stmt) token.Pos {
+	var doc *CommentGroup
+	switch c := c.(type) {
+	case *ElseIfClause:
+		doc = c.Doc
+	case *ElseClause:
+		doc = c.Doc
+	}
+	if doc != nil {
+		return doc.Pos()
+	}
+	return c.Pos()
+}
+
+// elseClause prints the "else if" or "else" branch c of an if
+// statement, whose body runs until the position until.
+func (p *printer) elseClause(c Stmt, until token.Pos) {
+	p.flush(c.Pos())
+	switch c := c.(type) {
+	case *ElseIfClause:
+		p.doc(c.Doc)
+		p.cond(c, c.Pos(), c.Cond, token.ELSE, token.IF)
+		p.block(body(c.Body), until)
+	case *ElseClause:
+		p.doc(c.Doc)
+		p.line(c.Else, after(c.Else, len(token.ELSE)), token.ELSE)
+		p.block(body(c.Body), until)
+	}
+}
+
+func (p *printer) caseClause(c *CaseClause, until token.Pos) {
+	p.flush(c.Case)
+	p.doc(c.Doc)
+	words := []string{token.CASE}
+	end := after(c.Case, len(token.CASE))
+	for _, x := range c.Patterns {
+		words = append(words, p.pattern(x))
+		end = x.End()
+	}
+	p.line(c.Case, end, words...)
+	p.block(body(c.Body), until)
+}
 
-			p.println(token.CASE, strings.Join(cs, " "))
+func (p *printer) decl(d Decl) {
+	p.flush(d.Pos())
 
-			temp := p.ident
-			p.ident += "  "
-			p.block(c.Body.List)
-			p.ident = temp
+	switch d := d.(type) {
+	case *FuncDecl:
+		p.doc(d.Doc)
+		words := []string{token.FUNCTION, p.expr(d.Name)}
+		end := d.Name.End()
+		for _, x := range d.Recv {
+			words = append(words, p.expr(x))
+			if x.End() > end {
+				end = x.End()
+			}
+		}
+		for _, w := range p.funcWords(d) {
+			words = append(words, w.text)
+			if w.end > end {
+				end = w.end
+			}
 		}
+		p.line(d.Function, end, words...)
+		p.block(body(d.Body), d.EndPos)
+		p.end(d.EndPos, nil)
+	}
+}
 
-		if n.Else != nil {
-			p.println(token.CASE, "'*'")
+// file prints the declarations and statements of f in source order.
+func (p *printer) file(f *File) {
+	if f.Doc != nil && p.useDocs {
+		p.doc(f.Doc)
+		p.line(token.NoPos, token.NoPos)
+	}
 
-			temp := p.ident
-			p.ident += "  "
-			p.block(n.Else.List)
-			p.ident = temp
+	for _, n := range f.Nodes() {
+		switch n := n.(type) {
+		case Decl:
+			p.decl(n)
+		case Stmt:
+			p.stmt(n)
 		}
+	}
+	p.flush(token.NoPos)
+}
 
-		p.println(token.END)
+// Fprint prints node as fish source to w, as laid out by cfg. Position
+// information is interpreted relative to the file set fset, which may
+// be nil for a tree without positions. With a file set, the blank lines
+// of the source are kept, and the comments of a parsed *File are printed
+// where they appeared; without one, the Doc fields are printed instead.
+//
+// Nodes that have no fish spelling are left out; the first of them is
+// reported as an *UnprintableError once the rest has been printed.
+func (cfg *PrintConfig) Fprint(w io.Writer, fset *token.FileSet, node Node) error {
+	var p printer
+	p.init(cfg, fset, node)
 
-	case *ExprStmt:
-		p.println(ExprStr(n.X))
+	switch n := node.(type) {
+	case *File:
+		p.file(n)
+	case Decl:
+		p.decl(n)
+	case Stmt:
+		p.stmt(n)
+	case *CommentGroup:
+		for _, c := range n.List {
+			p.line(c.Pos(), token.NoPos, c.Text)
+		}
+	case *Pipe:
+		p.line(n.Pos(), n.End(), pipe(n))
+	case *FuncOpt:
+		p.line(n.Pos(), n.End(), p.funcOpt(n))
+	case Expr:
+		p.line(n.Pos(), n.End(), p.words(nil, n)...)
+	default:
+		p.unprintable(n, "not a statement or an expression")
+	}
+
+	if _, err := w.Write(p.output.Bytes()); err != nil {
+		return err
 	}
-	return nil
+	return p.err
+}
+
+// Fprint prints node as fish source to w, indented the way fish_indent
+// indents it. Bash constructs with a fish counterpart are translated:
+// [ ] and [[ ]] become test, (( )) and $(( )) become math, and ${ }
+// expansions become string subcommands where fish has one.
+//
+// Nodes that have no fish spelling are left out of the output; the
+// first of them is reported as an *UnprintableError once the rest of
+// the tree has been printed.
+func Fprint(w io.Writer, node Node) error {
+	return (&PrintConfig{}).Fprint(w, nil, node)
 }
 
+// Print prints node as fish source to standard output. See Fprint.
 func Print(node Node) {
-	Walk(&printer{ident: "", output: os.Stdout}, node)
+	Fprint(os.Stdout, node)
 }
 
+// String returns node as fish source. See Fprint.
 func String(node Node) string {
 	buf := &strings.Builder{}
-	Walk(&printer{ident: "", output: buf}, node)
+	Fprint(buf, node)
 	return buf.String()
 }
 
+// ExprStr returns e as fish source. See Fprint.
 func ExprStr(e Expr) string {
+	return new(printer).expr(e)
+}
+
+// ExprListStr returns the expressions of list as fish source,
+// separated by blanks. See Fprint.
+func ExprListStr(list []Expr) string {
+	return new(printer).exprList(list)
+}
+
+// join joins the non-empty words with blanks.
+func join(words ...string) string {
+	var b strings.Builder
+	for _, w := range words {
+		if w == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+// funcOpt returns the spelling of the function option o.
+func (p *printer) funcOpt(o *FuncOpt) string {
+	if o.Opt == "" {
+		p.unprintable(o, "function option has no name")
+	}
+	var s string
+	switch {
+	case o.Value == nil:
+		return o.Opt
+	case o.Eq.IsValid():
+		s = o.Opt + "=" + p.expr(o.Value)
+	default:
+		s = join(o.Opt, p.expr(o.Value))
+	}
+	return join(s, p.exprList(o.Names))
+}
+
+// A funcWord is an option, the "--" or another word of a function
+// declaration, as printed.
+type funcWord struct {
+	pos, end token.Pos
+	text     string
+}
+
+// funcWords returns the words of the function declaration d after its
+// name, in source order. If some have no position, the options come
+// first, in the order of Opts, followed by the "--" and the Args.
+func (p *printer) funcWords(d *FuncDecl) []funcWord {
+	var list []funcWord
+	for _, o := range d.Opts() {
+		list = append(list, funcWord{o.Pos(), o.End(), p.funcOpt(o)})
+	}
+	if d.DashDash.IsValid() {
+		list = append(list, funcWord{d.DashDash, d.DashDash + 2, "--"})
+	}
+	for _, x := range d.Args {
+		list = append(list, funcWord{x.Pos(), x.End(), p.expr(x)})
+	}
+	for _, w := range list {
+		if !w.pos.IsValid() {
+			return list
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].pos < list[j].pos })
+	return list
+}
+
+// pipe returns the spelling of the pipe operator x.
+func pipe(x *Pipe) string {
+	if x.Op == token.GT_PIPE && x.Fd != x.Op.DefaultFd() {
+		return strconv.Itoa(x.Fd) + string(x.Op)
+	}
+	if x.Op == token.GT_PIPE || x.Op == token.NONE {
+		return token.BITOR // ">|" is the same as "|"
+	}
+	return string(x.Op)
+}
+
+// isRedirect reports whether op is a redirection, such as ">", "2>>"
+// or "2>&", as opposed to a pipe such as "2>|".
+func isRedirect(op token.Token) bool {
+	return strings.ContainsAny(string(op), "<>^") && !strings.HasSuffix(string(op), "|")
+}
+
+func (p *printer) exprList(list []Expr) string {
+	words := make([]string, len(list))
+	for i, e := range list {
+		words[i] = p.expr(e)
+	}
+	return join(words...)
+}
+
+// concat returns the parts of a word written one after another.
+func (p *printer) concat(parts []Expr) string {
+	var b strings.Builder
+	for _, x := range parts {
+		b.WriteString(p.expr(x))
+	}
+	return b.String()
+}
+
+func (p *printer) expr(e Expr) string {
 	switch e := e.(type) {
+	case nil:
+		return ""
+
+	case *BadExpr:
+		p.unprintable(e, "expression contains syntax errors")
+
 	case *Ident:
 		return e.Name
 
 	case *BasicLit:
 		if e.Kind == token.STRING {
-			return fmt.Sprintf(`"%s"`, e.Value)
+			return quote.Quote(e.Value)
 		}
 		return e.Value
 
 	case *CallExpr:
-		return fmt.Sprintf("%s %s", ExprStr(e.Func), ExprListStr(e.Recv))
+		words := []string{p.expr(e.Func), p.exprList(e.Recv)}
+		for _, r := range e.Redirs {
+			words = append(words, p.expr(r))
+		}
+		return join(words...)
+
+	case *StatusCall:
+		words := []string{"status"}
+		if e.Sub != nil {
+			words = append(words, e.Sub.Name)
+		}
+		words = append(words, p.exprList(e.Args))
+		for _, r := range e.Redirs {
+			words = append(words, p.expr(r))
+		}
+		return join(words...)
+
+	case *Redirect:
+		// the legacy "^" and "^^" are spelled the way fish 3 does;
+		// fish_indent attaches the target to the operator
+		op := string(e.Op)
+		switch e.Op {
+		case token.XOR:
+			op = "2>"
+		case token.DOUBLE_XOR:
+			op = "2>>"
+		case token.AND_LT, token.AND_DOUBLE_GT:
+			// both 1 and 2 are redirected; no fd can be written
+		default:
+			if e.Fd != e.Op.DefaultFd() {
+				op = strconv.Itoa(e.Fd) + op
+			}
+		}
+		word := p.expr(e.Word)
+		if strings.HasSuffix(op, ">") || strings.HasSuffix(op, "<") {
+			// a target such as "?x" would turn "> ?x" into ">?"
+			if word != "" && strings.ContainsRune("<>&?|", rune(word[0])) {
+				return op + " " + word
+			}
+		}
+		return op + word
+
+	case *Word:
+		return p.concat(e.Parts)
+
+	case *Lit:
+		return e.Value
+
+	case *Glob:
+		return e.Value
+
+	case *SingleQuoted:
+		return "'" + e.Value + "'"
+
+	case *DoubleQuoted:
+		return `"` + p.concat(e.Parts) + `"`
+
+	case *BraceExp:
+		elems := make([]string, len(e.Elems))
+		for i, x := range e.Elems {
+			elems[i] = p.expr(x)
+		}
+		return "{" + strings.Join(elems, ",") + "}"
+
+	case *VarRef:
+		return p.expr(e.Name) + p.expr(e.Index)
+
+	case *VarExpansion:
+		x := "$" + p.expr(e.X)
+		for _, index := range e.Index {
+			x += p.expr(index)
+		}
+		if e.Lbrace.IsValid() {
+			return "{" + x + "}"
+		}
+		return x
+
+	case *IndexExpr:
+		if e == nil {
+			return ""
+		}
+		return "[" + p.exprList(e.List) + "]"
+
+	case *RangeExpr:
+		return p.expr(e.Low) + ".." + p.expr(e.High)
+
+	case *Pipeline:
+		words := []string{p.expr(e.Cmds[0])}
+		for i, x := range e.Cmds[1:] {
+			if i < len(e.Pipes) {
+				words = append(words, pipe(e.Pipes[i]))
+			} else {
+				words = append(words, token.BITOR)
+			}
+			words = append(words, p.expr(x))
+		}
+		return join(words...)
+
+	case *Job:
+		amp := ""
+		if e.Amp.IsValid() {
+			amp = token.BITAND
+		}
+		return join(string(e.NotTok), p.expr(e.X), amp)
+
+	case *JobConjunction:
+		words := []string{string(e.DecTok), p.expr(e.Jobs[0])}
+		for i, x := range e.Jobs[1:] {
+			op := token.Token(token.AND)
+			if i < len(e.Ops) {
+				op = e.Ops[i]
+			}
+			words = append(words, string(op), p.expr(x))
+		}
+		return join(words...)
+
+	case *StmtExpr:
+		return p.inline(e.Stmt)
+
+	case *JobList:
+		stmts := make([]string, len(e.List))
+		for i, s := range e.List {
+			stmts[i] = p.inline(s)
+		}
+		return strings.Join(stmts, "; ")
+
+	case *BinaryExpr:
+		x, y := p.expr(e.X), p.expr(e.Y)
+		switch {
+		case e.Op == token.NONE && e.Compress:
+			return x + y
+		case e.Op == token.NONE:
+			return join(x, y)
+		case isRedirect(e.Op):
+			// fish_indent attaches the target to the redirection
+			return x + " " + string(e.Op) + y
+		case e.Compress:
+			return x + string(e.Op) + y
+		}
+		return join(x, string(e.Op), y)
 
 	case *BasicTestExpr:
-		return fmt.Sprintf("[ %s ]", ExprStr(e.X))
+		return join("test", p.testExpr(e.X))
 
 	case *ExtendedTestExpr:
-		return fmt.Sprintf("[[ %s ]]", ExprStr(e.X))
+		// a regular expression match is spelled with string match
+		if b, ok := e.X.(*BinaryExpr); ok && b.Op == "=~" {
+			return join("string match -qr --", p.regexp(b.Y), p.expr(b.X))
+		}
+		return join("test", p.testExpr(e.X))
 
 	case *ArithEvalExpr:
-		return fmt.Sprintf("(( %s ))", ExprStr(e.X))
+		// (( x )) succeeds if x is not zero
+		return join("test", "(math "+p.mathExpr(e.X)+")", "-ne", "0")
+
+	case *CmdGroup:
+		list := make([]string, len(e.List))
+		for i, s := range e.List {
+			list[i] = p.inline(s)
+		}
+		if e.Lbrace.IsValid() {
+			return join(token.BEGIN+";", strings.Join(list, "; ")+";", token.END)
+		}
+		return strings.Join(list, "; ")
 
 	case *CmdSubst:
-		if e.Tok == token.LPAREN {
-			return fmt.Sprintf("$( %s )", ExprStr(e.X))
+		if e.Dollar.IsValid() || e.Quoted {
+			return "$(" + p.expr(e.X) + ")"
 		}
-		return fmt.Sprintf("` %s `", ExprStr(e.X))
+		return "(" + p.expr(e.X) + ")"
 
 	case *ProcSubst:
 		if e.Tok == token.LT {
-			return fmt.Sprintf("<( %s )", ExprStr(e.X))
+			return "(" + p.expr(e.X) + " | psub)"
 		}
-		return fmt.Sprintf(">( %s )", ExprStr(e.X))
+		p.unprintable(e, "fish cannot substitute a process that is written to")
 
 	case *ArithExp:
-		return fmt.Sprintf("$(( %s ))", ExprStr(e.X))
+		return "(math " + p.mathExpr(e.X) + ")"
 
-	case *BinaryExpr:
-		if e.Op == token.NONE {
-			if e.Compress {
-				return fmt.Sprintf("%s%s", ExprStr(e.X), ExprStr(e.Y))
-			}
-			return fmt.Sprintf("%s %s", ExprStr(e.X), ExprStr(e.Y))
+	case *ParamExp:
+		return p.paramExp(e)
+
+	default:
+		p.unprintable(e, "unknown expression")
+	}
+	return ""
+}
+
+// inline returns the single-line spelling of s, as used inside
+// substitutions and command groups.
+func (p *printer) inline(s Stmt) string {
+	switch s := s.(type) {
+	case *ExprStmt:
+		return p.expr(s.X)
+	case *ReturnStmt:
+		return join(p.exit(nil, token.RETURN, s.X, s.Redirs)...)
+	case *ExitStmt:
+		return join(p.exit(nil, "exit", s.X, s.Redirs)...)
+	case *SetStmt:
+		return p.setStmt(s)
+	case *BreakStmt:
+		return token.BREAK
+	case *ContinueStmt:
+		return token.CONTINUE
+	}
+
+	// the lines of a block are joined without its comments,
+	// which would comment out the rest of the line
+	q := &printer{PrintConfig: PrintConfig{Indent: 4}}
+	q.stmt(s)
+	if q.err != nil && p.err == nil {
+		p.err = q.err
+	}
+	lines := strings.Split(strings.TrimSuffix(q.output.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, "; ")
+}
+
+// setStmt returns the spelling of s, with its options written
+// the canonical way.
+func (p *printer) setStmt(s *SetStmt) string {
+	words := append([]string{"set"}, s.Options()...)
+	for _, v := range s.Vars {
+		words = append(words, p.expr(v))
+	}
+	words = append(words, p.exprList(s.Values))
+	for _, r := range s.Redirs {
+		words = append(words, p.expr(r))
+	}
+	return join(words...)
+}
+
+// testExpr returns the arguments of the test command for x.
+func (p *printer) testExpr(x Expr) string {
+	b, ok := x.(*BinaryExpr)
+	if !ok || b.Compress {
+		return p.expr(x)
+	}
+	op := string(b.Op)
+	switch b.Op {
+	case token.EQ:
+		op = "="
+	case token.AND:
+		op = "-a"
+	case token.OR:
+		op = "-o"
+	case token.LT, token.GT, "=~":
+		p.unprintable(b, "test cannot compare with "+op)
+		return ""
+	}
+	return join(p.testExpr(b.X), op, p.testExpr(b.Y))
+}
+
+// mathExpr returns the argument of the math command for x,
+// quoted if it would otherwise be expanded by fish.
+func (p *printer) mathExpr(x Expr) string {
+	s := p.expr(x)
+	if strings.ContainsAny(s, " *?()<>|&;[]{}#~") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// pattern returns the spelling of a case pattern. Glob characters in
+// unquoted words are quoted: switch matches them itself and they must
+// not be expanded as file names.
+func (p *printer) pattern(x Expr) string {
+	if s, ok := globLit(x); ok {
+		return quote.Single(s)
+	}
+	return p.expr(x)
+}
+
+// globLit returns the text of x if it is unquoted literal text that
+// contains the wildcard "*" or "?".
+func globLit(x Expr) (string, bool) {
+	var s string
+	switch x := x.(type) {
+	case *Ident:
+		s = x.Name
+	case *Word:
+		if len(x.Parts) != 1 {
+			return "", false
 		}
-		if e.Compress {
-			return fmt.Sprintf("%s%s%s", ExprStr(e.X), e.Op, ExprStr(e.Y))
+		switch part := x.Parts[0].(type) {
+		case *Lit:
+			s = part.Value
+		case *Glob:
+			s = part.Value
 		}
-		return fmt.Sprintf("%s %s %s", ExprStr(e.X), e.Op, ExprStr(e.Y))
+	default:
+		return "", false
+	}
+	if !strings.ContainsAny(s, "*?") || strings.ContainsAny(s, `$'"()\`) {
+		return "", false
+	}
+	return s, true
+}
 
-	case *ParamExp:
-		switch {
-		case e.DefaultValExp != nil:
-			return fmt.Sprintf("${%s:-%s}", e.Var, e.DefaultValExp.Val)
-		case e.DefaultValAssignExp != nil:
-			return fmt.Sprintf("${%s:=%s}", e.Var, e.DefaultValAssignExp.Val)
-		case e.NonNullCheckExp != nil:
-			return fmt.Sprintf("${%s:?%s}", e.Var, e.NonNullCheckExp.Val)
-		case e.NonNullExp != nil:
-			return fmt.Sprintf("${%s:+%s}", e.Var, e.NonNullExp.Val)
-		case e.PrefixExp != nil:
-			return fmt.Sprintf("${!%s*}", e.Var)
-		case e.PrefixArrayExp != nil:
-			return fmt.Sprintf("${!%s@}", e.Var)
-		case e.ArrayIndexExp != nil:
-			if e.Tok == token.MUL {
-				return fmt.Sprintf("${!%s[*]}", e.Var)
-			}
-			return fmt.Sprintf("${!%s[@]}", e.Var)
-		case e.LengthExp != nil:
-			return fmt.Sprintf("${#%s}", e.Var)
-		case e.DelPrefix != nil:
-			if e.DelPrefix.Longest {
-				return fmt.Sprintf("${%s##%s}", e.Var, e.DelPrefix.Val)
-			}
-			return fmt.Sprintf("${%s#%s}", e.Var, e.DelPrefix.Val)
-		case e.DelSuffix != nil:
-			if e.DelSuffix.Longest {
-				return fmt.Sprintf("${%s%%%%%s}", e.Var, e.DelPrefix.Val)
-			}
-			return fmt.Sprintf("${%s%%%s}", e.Var, e.DelPrefix.Val)
-		case e.SubstringExp != nil:
-			if e.SubstringExp.Offset != e.SubstringExp.Length {
-				return fmt.Sprintf("${%s:%d:%d}", e.Var, e.SubstringExp.Offset, e.SubstringExp.Length)
+// regexp returns the regular expression x in single quotes.
+func (p *printer) regexp(x Expr) string {
+	switch x := x.(type) {
+	case *Ident:
+		return quote.Single(x.Name)
+	case *BasicLit:
+		if x.Kind == token.STRING || x.Kind == token.NUMBER {
+			return quote.Single(x.Value)
+		}
+	}
+	return p.expr(x)
+}
+
+// literal returns the text of a literal pattern or replacement.
+func (p *printer) literal(x Expr) (string, bool) {
+	switch x := x.(type) {
+	case *Ident:
+		return x.Name, true
+	case *BasicLit:
+		if x.Kind == token.STRING || x.Kind == token.NUMBER {
+			return x.Value, true
+		}
+	}
+	return "", false
+}
+
+// globRegexp translates the glob pattern into a regular expression.
+// With lazy set, "*" matches as few characters as possible.
+func globRegexp(pattern string, lazy bool) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(".*")
+			if lazy {
+				b.WriteByte('?')
 			}
-			return fmt.Sprintf("${%s:%d}", e.Var, e.SubstringExp.Offset)
-		case e.ReplaceExp != nil:
-			return fmt.Sprintf("${%s/%s/%s}", e.Var, e.ReplaceExp.Old, e.ReplaceExp.New)
-		case e.ReplacePrefixExp != nil:
-			return fmt.Sprintf("${%s/#%s/%s}", e.Var, e.ReplacePrefixExp.Old, e.ReplacePrefixExp.New)
-		case e.ReplaceSuffixExp != nil:
-			return fmt.Sprintf("${%s/%%%s/%s}", e.Var, e.ReplaceSuffixExp.Old, e.ReplaceSuffixExp.New)
-		case e.CaseConversionExp != nil:
-			if e.CaseConversionExp.FirstChar && e.CaseConversionExp.ToUpper {
-				return fmt.Sprintf("${%s^}", e.Var)
-			} else if !e.CaseConversionExp.FirstChar && e.CaseConversionExp.ToUpper {
-				return fmt.Sprintf("${%s^^}", e.Var)
-			} else if e.CaseConversionExp.FirstChar && !e.CaseConversionExp.ToUpper {
-				return fmt.Sprintf("${%s,}", e.Var)
-			} else {
-				return fmt.Sprintf("${%s,,}", e.Var)
+		case '?':
+			b.WriteByte('.')
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexpQuote(pattern[i : i+1]))
 			}
-		case e.OperatorExp != nil:
-			return fmt.Sprintf("${%s@%s}", e.Var, e.OperatorExp.Op)
+		case '[', ']':
+			b.WriteByte(c)
+		default:
+			b.WriteString(regexpQuote(string(c)))
 		}
 	}
-	return ""
+	return b.String()
 }
 
-func ExprListStr(list []Expr) string {
-	res := []string{}
-	for _, e := range list {
-		res = append(res, ExprStr(e))
+// regexpQuote escapes the characters of s that are special in a
+// regular expression.
+func regexpQuote(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`.+*?()|[]{}^$\`, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// paramExp translates a parameter expansion into a variable expansion
+// or a command substitution of the string builtin.
+func (p *printer) paramExp(e *ParamExp) string {
+	name := strings.TrimPrefix(p.expr(e.Var), "$")
+	v := "$" + name
+	str := func(args ...string) string {
+		return "(" + join(append([]string{"string"}, args...)...) + ")"
+	}
+	firstChar := func(sub string) string {
+		return "(string sub -l 1 -- " + v + " | string " + sub + ")" + str("sub", "-s", "2", "--", v)
 	}
-	return strings.Join(res, " ")
+
+	switch {
+	case e.DefaultValExp != nil, e.DefaultValAssignExp != nil,
+		e.NonNullCheckExp != nil, e.NonNullExp != nil:
+		p.unprintable(e, "fish has no conditional expansion; test the variable with set -q")
+		return ""
+
+	case e.PrefixExp != nil, e.PrefixArrayExp != nil:
+		return "(set --names | string match -- " + quote.Single(name+"*") + ")"
+
+	case e.ArrayIndexExp != nil:
+		return "(seq (count " + v + "))"
+
+	case e.LengthExp != nil:
+		return str("length", "--", v)
+
+	case e.DelPrefix != nil:
+		pat, ok := p.literal(e.DelPrefix.Val)
+		if !ok {
+			break
+		}
+		return str("replace", "-r", "--", quote.Single("^"+globRegexp(pat, !e.DelPrefix.Longest)), "''", v)
+
+	case e.DelSuffix != nil:
+		pat, ok := p.literal(e.DelSuffix.Val)
+		if !ok {
+			break
+		}
+		// the prefix is matched the opposite way, so that the suffix
+		// is as short or as long as asked for
+		prefix := "^(.*)"
+		if e.DelSuffix.Longest {
+			prefix = "^(.*?)"
+		}
+		return str("replace", "-r", "--", quote.Single(prefix+globRegexp(pat, !e.DelSuffix.Longest)+"$"), "'$1'", v)
+
+	case e.SubstringExp != nil:
+		start := e.SubstringExp.Offset
+		if start >= 0 {
+			start++ // string sub counts from 1
+		}
+		if e.SubstringExp.Offset != e.SubstringExp.Length {
+			return str("sub", "-s", strconv.Itoa(start), "-l", strconv.Itoa(e.SubstringExp.Length), "--", v)
+		}
+		return str("sub", "-s", strconv.Itoa(start), "--", v)
+
+	case e.ReplaceExp != nil:
+		all := ""
+		if e.ReplaceExp.All {
+			all = "-a"
+		}
+		if strings.ContainsAny(e.ReplaceExp.Old, "*?[") {
+			return str("replace", all, "-r", "--", quote.Single(globRegexp(e.ReplaceExp.Old, false)), quote.Single(e.ReplaceExp.New), v)
+		}
+		return str("replace", all, "--", quote.Single(e.ReplaceExp.Old), quote.Single(e.ReplaceExp.New), v)
+
+	case e.ReplacePrefixExp != nil:
+		return str("replace", "-r", "--", quote.Single("^"+globRegexp(e.ReplacePrefixExp.Old, false)), quote.Single(e.ReplacePrefixExp.New), v)
+
+	case e.ReplaceSuffixExp != nil:
+		return str("replace", "-r", "--", quote.Single(globRegexp(e.ReplaceSuffixExp.Old, false)+"$"), quote.Single(e.ReplaceSuffixExp.New), v)
+
+	case e.CaseConversionExp != nil:
+		sub := "lower"
+		if e.CaseConversionExp.ToUpper {
+			sub = "upper"
+		}
+		if e.CaseConversionExp.FirstChar {
+			return firstChar(sub)
+		}
+		return str(sub, "--", v)
+
+	case e.OperatorExp != nil:
+		switch e.OperatorExp.Op {
+		case ExpOperatorU:
+			return str("upper", "--", v)
+		case ExpOperatorL:
+			return str("lower", "--", v)
+		case ExpOperatoru:
+			return firstChar("upper")
+		case ExpOperatorQ:
+			return str("escape", "--", v)
+		}
+		p.unprintable(e, "fish has no counterpart to the @"+string(e.OperatorExp.Op)+" operator")
+		return ""
+
+	default:
+		return v
+	}
+
+	p.unprintable(e, "the pattern must be a literal")
+	return ""
 }
@@ -3,6 +3,8 @@
 // license that can be found in the LICENSE file.
 package ast
 
+import "fmt"
+
 // A Visitor's Visit method is invoked for each node encountered by Walk.
 // If the result visitor w is not nil, Walk visits each of the children
 // of node with the visitor w, followed by a call of w.Visit(nil).
@@ -10,6 +12,17 @@ type Visitor interface {
 	Visit(node Node) (w Visitor)
 }
 
+func walkList[N Node](v Visitor, list []N) {
+	for _, node := range list {
+		Walk(v, node)
+	}
+}
+
+// Walk traverses an AST in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor
+// w for each of the non-nil children of node, followed by a call of
+// w.Visit(nil).
 func Walk(v Visitor, node Node) {
 	if v = v.Visit(node); v == nil {
 		return
@@ -19,35 +32,318 @@ func Walk(v Visitor, node Node) {
 	// (the order of the cases matches the order
 	// of the corresponding node types in ast.go)
 	switch n := node.(type) {
-	// Comments and fields
+	// Comments
 	case *Comment:
 		// nothing to do
 
-	case *Ident:
+	case *CommentGroup:
+		walkList(v, n.List)
+
+	// Declarations
+	case *FuncDecl:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Name != nil {
+			Walk(v, n.Name)
+		}
+		if n.Description != nil {
+			Walk(v, n.Description)
+		}
+		walkList(v, n.ArgNames)
+		walkList(v, n.Wraps)
+		walkList(v, n.OnEvent)
+		walkList(v, n.OnVariable)
+		walkList(v, n.OnSignal)
+		walkList(v, n.OnJobExit)
+		walkList(v, n.OnProcessExit)
+		walkList(v, n.InheritVariable)
+		if n.NoScopeShadowing != nil {
+			Walk(v, n.NoScopeShadowing)
+		}
+		walkList(v, n.Args)
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+		walkList(v, n.Recv)
+
+	case *FuncOpt:
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		walkList(v, n.Names)
+
+	// Statements
+	case *BadStmt:
 		// nothing to do
 
+	case *AssignStmt:
+		Walk(v, n.Lhs)
+		Walk(v, n.Rhs)
+
+	case *SetStmt:
+		walkList(v, n.Vars)
+		walkList(v, n.Values)
+		walkList(v, n.Redirs)
+
+	case *BlockStmt:
+		walkList(v, n.List)
+
+	case *ExprStmt:
+		Walk(v, n.X)
+
+	case *DeclStmt:
+		Walk(v, n.Decl)
+
 	case *ReturnStmt:
-		Walk(v, n)
+		if n.X != nil {
+			Walk(v, n.X)
+		}
+		walkList(v, n.Redirs)
+
+	case *ExitStmt:
+		if n.X != nil {
+			Walk(v, n.X)
+		}
+		walkList(v, n.Redirs)
 
-	case *BreakStmt:
-		Walk(v, n)
+	case *BreakStmt, *ContinueStmt:
+		// nothing to do
 
-	case *ContinueStmt:
-		Walk(v, n)
+	case *BeginStmt:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+		walkList(v, n.Redirs)
 
 	case *WhileStmt:
-		Walk(v, n)
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Cond != nil {
+			Walk(v, n.Cond)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+		walkList(v, n.Redirs)
 
 	case *ForeachStmt:
-		Walk(v, n)
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Elem != nil {
+			Walk(v, n.Elem)
+		}
+		walkList(v, n.Group)
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+		walkList(v, n.Redirs)
 
 	case *IfStmt:
-		Walk(v, n)
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Cond != nil {
+			Walk(v, n.Cond)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+		walkList(v, n.Elif)
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+		walkList(v, n.Redirs)
+
+	case *ElseIfClause:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Cond != nil {
+			Walk(v, n.Cond)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+
+	case *ElseClause:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
 
 	case *SwitchStmt:
-		Walk(v, n)
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		if n.Value != nil {
+			Walk(v, n.Value)
+		}
+		walkList(v, n.Cases)
+		walkList(v, n.Redirs)
 
-	case *ExprStmt:
-		Walk(v, n)
+	case *CaseClause:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		walkList(v, n.Patterns)
+		if n.Body != nil {
+			Walk(v, n.Body)
+		}
+
+	// Expressions
+	case *BadExpr, *Ident, *BasicLit:
+		// nothing to do
+
+	case *BinaryExpr:
+		Walk(v, n.X)
+		Walk(v, n.Y)
+
+	case *CallExpr:
+		Walk(v, n.Func)
+		walkList(v, n.Recv)
+		walkList(v, n.Redirs)
+
+	case *StatusCall:
+		if n.Sub != nil {
+			Walk(v, n.Sub)
+		}
+		walkList(v, n.Args)
+		walkList(v, n.Redirs)
+
+	case *Redirect:
+		Walk(v, n.Word)
+
+	case *Word:
+		walkList(v, n.Parts)
+
+	case *Lit, *Glob, *SingleQuoted:
+		// nothing to do
+
+	case *DoubleQuoted:
+		walkList(v, n.Parts)
+
+	case *BraceExp:
+		walkList(v, n.Elems)
+
+	case *VarRef:
+		Walk(v, n.Name)
+		if n.Index != nil {
+			Walk(v, n.Index)
+		}
+
+	case *VarExpansion:
+		Walk(v, n.X)
+		walkList(v, n.Index)
+
+	case *IndexExpr:
+		walkList(v, n.List)
+
+	case *RangeExpr:
+		if n.Low != nil {
+			Walk(v, n.Low)
+		}
+		if n.High != nil {
+			Walk(v, n.High)
+		}
+
+	case *Pipeline:
+		walkList(v, n.Cmds)
+		walkList(v, n.Pipes)
+
+	case *Pipe:
+		// nothing to do
+
+	case *Job:
+		Walk(v, n.X)
+
+	case *JobConjunction:
+		walkList(v, n.Jobs)
+
+	case *StmtExpr:
+		Walk(v, n.Stmt)
+
+	case *JobList:
+		walkList(v, n.List)
+
+	case *BasicTestExpr:
+		Walk(v, n.X)
+
+	case *ExtendedTestExpr:
+		Walk(v, n.X)
+
+	case *ArithEvalExpr:
+		Walk(v, n.X)
+
+	case *CmdGroup:
+		walkList(v, n.List)
+
+	case *CmdSubst:
+		Walk(v, n.X)
+
+	case *ProcSubst:
+		Walk(v, n.X)
+
+	case *ArithExp:
+		Walk(v, n.X)
+
+	case *ParamExp:
+		Walk(v, n.Var)
+		switch {
+		case n.DefaultValExp != nil:
+			Walk(v, n.DefaultValExp.Val)
+		case n.DefaultValAssignExp != nil:
+			Walk(v, n.DefaultValAssignExp.Val)
+		case n.NonNullCheckExp != nil:
+			Walk(v, n.NonNullCheckExp.Val)
+		case n.NonNullExp != nil:
+			Walk(v, n.NonNullExp.Val)
+		case n.DelPrefix != nil:
+			Walk(v, n.DelPrefix.Val)
+		case n.DelSuffix != nil:
+			Walk(v, n.DelSuffix.Val)
+		}
+
+	// Files
+	case *File:
+		if n.Doc != nil {
+			Walk(v, n.Doc)
+		}
+		// walk n.Decls and n.Stmts in source order
+		for _, node := range n.Nodes() {
+			Walk(v, node)
+		}
+		// don't walk n.Comments - they have been
+		// visited already through the individual
+		// nodes
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
 	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
 }
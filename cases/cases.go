@@ -0,0 +1,140 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package cases implements the matching of the values of switch
+// statements against the patterns of their cases, so that the case
+// a known value runs can be found without running fish. (The package
+// is not named after the "case" keyword, which is also one in Go.)
+//
+// Unlike file name wildcards, the patterns of a case are not expanded
+// over files: "*" matches any string, "/" and a leading "." included,
+// whether it is quoted or not. Thus case '*' is the catch-all case.
+// A backslash left by the quote removal escapes a following "*" or
+// backslash, and is kept before any other character, so that '\*'
+// matches a "*" alone. As with the default mode of the glob package,
+// "?" matches itself.
+package cases
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/expand"
+	"github.com/hulo-io/fishparser/quote"
+)
+
+// Match reports whether value matches the case pattern, given as the
+// text of its word after the quote removal.
+func Match(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch {
+		case pattern[0] == '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			for i := 0; ; {
+				if Match(pattern, value[i:]) {
+					return true
+				}
+				if i == len(value) {
+					return false
+				}
+				_, w := utf8.DecodeRuneInString(value[i:])
+				i += w
+			}
+		case pattern[0] == '\\' && len(pattern) > 1 && (pattern[1] == '*' || pattern[1] == '\\'):
+			pattern = pattern[1:]
+		}
+		if value == "" || value[0] != pattern[0] {
+			return false
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
+
+// Patterns returns the patterns the case word x stands for, after its
+// brace expansions and quote removal. It reports false if they are
+// only known when fish runs, because x expands a variable, substitutes
+// a command or starts with "~".
+func Patterns(x ast.Expr) ([]string, bool) {
+	var w *ast.Word
+	switch x := x.(type) {
+	case *ast.Word:
+		w = x
+	case *ast.Ident:
+		w = &ast.Word{Parts: []ast.Expr{&ast.Lit{Value: x.Name}}}
+	default:
+		return nil, false
+	}
+	var patterns []string
+	for _, w := range expand.Braces(w) {
+		s, ok := text(w)
+		if !ok {
+			return nil, false
+		}
+		patterns = append(patterns, s)
+	}
+	return patterns, true
+}
+
+// text returns the text of the word w after the quote removal.
+func text(w *ast.Word) (string, bool) {
+	var b strings.Builder
+	for i, x := range w.Parts {
+		var s string
+		var err error
+		switch x := x.(type) {
+		case *ast.Lit:
+			if i == 0 && strings.HasPrefix(x.Value, "~") {
+				return "", false
+			}
+			s, err = quote.Unescape(x.Value, quote.Unquoted)
+		case *ast.Glob:
+			if i == 0 && strings.HasPrefix(x.Value, "~") {
+				return "", false
+			}
+			s, err = quote.Unescape(x.Value, quote.Unquoted)
+		case *ast.SingleQuoted:
+			s, err = quote.Unescape(x.Value, quote.SingleQuoted)
+		case *ast.DoubleQuoted:
+			var t strings.Builder
+			for _, part := range x.Parts {
+				lit, ok := part.(*ast.Lit)
+				if !ok {
+					return "", false
+				}
+				t.WriteString(lit.Value)
+			}
+			s, err = quote.Unescape(t.String(), quote.DoubleQuoted)
+		default:
+			return "", false
+		}
+		if err != nil {
+			return "", false
+		}
+		b.WriteString(s)
+	}
+	return b.String(), true
+}
+
+// Select returns the case of the switch statement s that runs when
+// its value is value: the first one with a pattern matching it, or nil
+// if none does. It reports false if this is only known when fish runs,
+// because a pattern tried before it is found is not static.
+func Select(s *ast.SwitchStmt, value string) (*ast.CaseClause, bool) {
+	for _, c := range s.Cases {
+		for _, x := range c.Patterns {
+			patterns, ok := Patterns(x)
+			if !ok {
+				return nil, false
+			}
+			for _, pattern := range patterns {
+				if Match(pattern, value) {
+					return c, true
+				}
+			}
+		}
+	}
+	return nil, true
+}
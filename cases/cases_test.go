@@ -0,0 +1,105 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package cases_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/cases"
+	"github.com/hulo-io/fishparser/parser"
+	"github.com/hulo-io/fishparser/token"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"*.fish", ".config.fish", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b", "ab/", false},
+		{"**", "é", true},
+		{"?", "a", false},
+		{"?", "?", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`\\*`, `\x`, true},
+		{`\x`, `\x`, true},
+		{"linux", "Linux", false},
+	}
+	for _, tt := range tests {
+		if got := cases.Match(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"*", []string{"*"}},
+		{"'*'", []string{"*"}},
+		{`'\*'`, []string{`\*`}},
+		{`"*.$ext"`, nil},
+		{"{x,y}86*", []string{"x86*", "y86*"}},
+		{"~/*", nil},
+		{"(uname)", nil},
+	}
+	for _, tt := range tests {
+		x, err := parser.ParseExpr("switch_value " + tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		got, ok := cases.Patterns(x.(*ast.CallExpr).Recv[0])
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Patterns(%s) = %q, %v, want %q", tt.src, got, ok, tt.want)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	const src = `switch $os
+case Linux 'Free*'
+    echo unix
+case "Dar"win
+    echo mac
+case '*'
+    echo other
+end
+switch $arch
+case $host_arch
+    echo native
+case '*'
+    echo cross
+end
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "switch.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := f.Stmts[0].(*ast.SwitchStmt)
+	for value, want := range map[string]int{"Linux": 0, "FreeBSD": 0, "Darwin": 1, "Windows": 2, "": 2} {
+		c, ok := cases.Select(s, value)
+		if !ok || c != s.Cases[want] {
+			t.Errorf("%q: got case %v, %v, want case %d", value, c, ok, want)
+		}
+	}
+
+	s.Cases = s.Cases[:2]
+	if c, ok := cases.Select(s, "Windows"); c != nil || !ok {
+		t.Errorf("got case %v, %v, want no case", c, ok)
+	}
+
+	s = f.Stmts[1].(*ast.SwitchStmt)
+	if c, ok := cases.Select(s, "arm64"); ok {
+		t.Errorf("got case %v for a pattern only known at run time", c)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package expand implements the expansions fish performs on the words
+// of a command before running it, as far as they can be done on the
+// syntax tree alone.
+package expand
+
+import (
+	"strings"
+
+	"github.com/hulo-io/fishparser/ast"
+)
+
+// Braces returns the words the brace expansions of w expand to, in
+// the order fish produces them: the items of the leftmost brace
+// expansion in turn, each followed by every expansion of the rest of
+// the word. Thus "{a,b}{1,2}" expands to "a1 a2 b1 b2", and nested
+// braces as in "{a,b{1,2}}" to "a b1 b2".
+//
+// A word without brace expansions is returned alone. Other parts,
+// such as variable expansions in "{$list,none}", are kept in the
+// words, which share them with w.
+func Braces(w *ast.Word) []*ast.Word {
+	k := -1
+	for i, x := range w.Parts {
+		if _, ok := x.(*ast.BraceExp); ok {
+			k = i
+			break
+		}
+	}
+	if k < 0 {
+		return []*ast.Word{w}
+	}
+
+	var words []*ast.Word
+	for _, elem := range w.Parts[k].(*ast.BraceExp).Elems {
+		for _, e := range Braces(elem) {
+			parts := make([]ast.Expr, 0, len(w.Parts)+len(e.Parts))
+			parts = append(parts, w.Parts[:k]...)
+			parts = append(parts, e.Parts...)
+			parts = append(parts, w.Parts[k+1:]...)
+			words = append(words, Braces(&ast.Word{Parts: trim(parts)})...)
+		}
+	}
+	return words
+}
+
+// trim drops the empty literal text left by empty items from parts,
+// keeping one if the word would be empty otherwise.
+func trim(parts []ast.Expr) []ast.Expr {
+	list := parts[:0]
+	for _, x := range parts {
+		if lit, ok := x.(*ast.Lit); ok && lit.Value == "" {
+			continue
+		}
+		list = append(list, x)
+	}
+	if len(list) == 0 {
+		return parts[:1]
+	}
+	return list
+}
+
+// CmdSubst returns the arguments the command substitution x produces
+// from the output of its commands. Unquoted, the output is split into
+// one argument per line, the last newline ending the last line, and
+// no output gives no argument. Quoted, it is a single argument without
+// its trailing newlines.
+//
+// As in fish, unquoted output piped last into "string split0" is
+// split at its NUL bytes instead of its lines.
+func CmdSubst(x *ast.CmdSubst, output string) []string {
+	if x.Quoted {
+		return []string{strings.TrimRight(output, "\n")}
+	}
+	sep := "\n"
+	if isSplit0(x.X) {
+		sep = "\x00"
+	}
+	output = strings.TrimSuffix(output, sep)
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, sep)
+}
+
+// isSplit0 reports whether the last command of the job x is
+// "string split0".
+func isSplit0(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Job:
+		return isSplit0(x.X)
+	case *ast.Pipeline:
+		return isSplit0(x.Cmds[len(x.Cmds)-1])
+	case *ast.CallExpr:
+		if x.Func.Name != "string" || len(x.Recv) == 0 {
+			return false
+		}
+		w, ok := x.Recv[0].(*ast.Word)
+		if !ok || len(w.Parts) != 1 {
+			return false
+		}
+		lit, ok := w.Parts[0].(*ast.Lit)
+		return ok && lit.Value == "split0"
+	}
+	return false
+}
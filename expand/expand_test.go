@@ -0,0 +1,78 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package expand_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/expand"
+	"github.com/hulo-io/fishparser/parser"
+)
+
+func TestBraces(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{"src/{cmd,internal}", []string{"src/cmd", "src/internal"}},
+		{"{a,b}{1,2}", []string{"a1", "a2", "b1", "b2"}},
+		{"{a,b{1,2}}", []string{"a", "b1", "b2"}},
+		{"file{,.bak}", []string{"file", "file.bak"}},
+		{"{,}", []string{"", ""}},
+		{"{{a,b}}", []string{"{a}", "{b}"}},
+		{"{$list,none}/x", []string{"$list/x", "none/x"}},
+		{"{'a b',c}d", []string{"'a b'd", "cd"}},
+		{"{$x}s", []string{"{$x}s"}},
+		{"plain", []string{"plain"}},
+	}
+	for _, tt := range tests {
+		x, err := parser.ParseExpr("echo " + tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		var got []string
+		for _, w := range expand.Braces(x.(*ast.CallExpr).Recv[0].(*ast.Word)) {
+			got = append(got, ast.ExprStr(w))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Braces(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCmdSubst(t *testing.T) {
+	tests := []struct {
+		src    string
+		output string
+		want   []string
+	}{
+		{"echo (ls)", "a\nb\n", []string{"a", "b"}},
+		{"echo $(ls)", "a\n\nb\n\n", []string{"a", "", "b", ""}},
+		{"echo (true)", "", nil},
+		{"echo (printf x)", "x", []string{"x"}},
+		{`echo "$(ls)"`, "a\nb\n\n", []string{"a\nb"}},
+		{`echo "$(true)"`, "", []string{""}},
+		{"echo (find . -print0 | string split0)", "a b\x00c\nd\x00", []string{"a b", "c\nd"}},
+	}
+	for _, tt := range tests {
+		x, err := parser.ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		var subst *ast.CmdSubst
+		ast.Inspect(x, func(n ast.Node) bool {
+			if s, ok := n.(*ast.CmdSubst); ok && subst == nil {
+				subst = s
+			}
+			return subst == nil
+		})
+		if got := expand.CmdSubst(subst, tt.output); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
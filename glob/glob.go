@@ -0,0 +1,447 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package glob implements fish wildcards: matching file names against
+// them, and expanding them over the files of an fs.FS.
+//
+// A pattern is a slash-separated path in which the wildcards are
+//
+//	"*"    matching any string not holding a "/"
+//	"**"   matching any string, "/" included; "**/" also matches no directory
+//	"?"    matching any single character but "/", in the QMark mode
+//
+// and a backslash escapes the character following it.
+//
+// As in fish, no wildcard matches the "." starting a hidden file name:
+// the name must be written with it, as in ".*", and "**" does not
+// descend into hidden directories.
+package glob
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/expand"
+	"github.com/hulo-io/fishparser/quote"
+)
+
+// A Mode value is a set of flags (or 0). They select the optional
+// fish features that change the meaning of wildcards.
+type Mode uint
+
+const (
+	QMark Mode = 1 << iota // "?" is a wildcard, as with the qmark-noglob feature off
+)
+
+// ErrNoMatch is returned when a wildcard matches no file, which fish
+// reports as an error for most commands.
+var ErrNoMatch = errors.New("no matches for wildcard")
+
+// ErrNotStatic is returned by Expand for a word whose value is only
+// known when fish runs, because it expands variables, substitutes
+// commands or starts with "~".
+var ErrNotStatic = errors.New("word is not static")
+
+// HasMeta reports whether pattern holds a wildcard in mode.
+func HasMeta(pattern string, mode Mode) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*':
+			return true
+		case '?':
+			if mode&QMark != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match reports whether name matches the pattern in mode. The only
+// possible error is path.ErrBadPattern, when pattern ends with a
+// backslash.
+func Match(pattern, name string, mode Mode) (bool, error) {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' {
+			if i++; i == len(pattern) {
+				return false, path.ErrBadPattern
+			}
+		}
+	}
+	return match(pattern, name, true, mode), nil
+}
+
+// match reports whether s matches the pattern p. The flag start is
+// set if s starts a path component.
+func match(p, s string, start bool, mode Mode) bool {
+	for len(p) > 0 {
+		switch {
+		case strings.HasPrefix(p, "**"):
+			rest := strings.TrimLeft(p, "*")
+			if strings.HasPrefix(rest, "/") && start && match(rest[1:], s, true, mode) {
+				return true
+			}
+			if start && strings.HasPrefix(s, ".") {
+				return false
+			}
+			for i := 0; ; {
+				if match(rest, s[i:], i == 0 && start || i > 0 && s[i-1] == '/', mode) {
+					return true
+				}
+				if i == len(s) || s[i] == '.' && i > 0 && s[i-1] == '/' {
+					return false
+				}
+				_, w := utf8.DecodeRuneInString(s[i:])
+				i += w
+			}
+		case p[0] == '*':
+			rest := p[1:]
+			if start && strings.HasPrefix(s, ".") {
+				return false
+			}
+			for i := 0; ; {
+				if match(rest, s[i:], i == 0 && start, mode) {
+					return true
+				}
+				if i == len(s) || s[i] == '/' {
+					return false
+				}
+				_, w := utf8.DecodeRuneInString(s[i:])
+				i += w
+			}
+		case p[0] == '?' && mode&QMark != 0:
+			if s == "" || s[0] == '/' || start && s[0] == '.' {
+				return false
+			}
+			_, w := utf8.DecodeRuneInString(s)
+			p, s, start = p[1:], s[w:], false
+		default:
+			c := p[0]
+			if c == '\\' && len(p) > 1 {
+				p = p[1:]
+				c = p[0]
+			}
+			if s == "" || s[0] != c {
+				return false
+			}
+			p, s, start = p[1:], s[1:], c == '/'
+		}
+	}
+	return s == ""
+}
+
+// Glob returns the names of the files of fsys that match pattern, in
+// the order fish lists them, which compares numbers in names by value.
+// A pattern ending with "/" only matches directories, whose names are
+// returned with it. A pattern starting with "/" is matched from the
+// root of fsys, and so are the names returned. The directories before
+// the first wildcard start the names as written, so "./*.fish" returns
+// names starting with "./", as in fish.
+//
+// A pattern without wildcards is returned alone, without checking the
+// file exists. Glob returns ErrNoMatch if the pattern matches nothing,
+// and path.ErrBadPattern if it ends with a backslash. I/O errors such
+// as unreadable directories are ignored.
+func Glob(fsys fs.FS, pattern string, mode Mode) ([]string, error) {
+	if _, err := Match(pattern, "", mode); err != nil {
+		return nil, err
+	}
+	if !HasMeta(pattern, mode) {
+		return []string{unescape(pattern)}, nil
+	}
+
+	root, rel := "", strings.TrimLeft(pattern, "/")
+	if len(rel) < len(pattern) {
+		root = "/"
+	}
+	dirOnly := strings.HasSuffix(rel, "/")
+	var prefix string // the directories before the first wildcard
+	for {
+		i := strings.IndexByte(rel, '/')
+		if i < 0 || HasMeta(rel[:i], mode) {
+			break
+		}
+		prefix, rel = prefix+rel[:i+1], rel[i+1:]
+	}
+	prefix = unescape(prefix)
+	var segs []string
+	for _, seg := range strings.Split(rel, "/") {
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+
+	dir := path.Clean("./" + prefix)
+	names := []string{dir}
+segments:
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		var next []string
+		switch {
+		case recursive(seg):
+			rest := strings.Join(segs[i:], "/")
+			for _, dir := range names {
+				next = append(next, walk(fsys, dir, rest, dirOnly, mode)...)
+			}
+			names = next
+			break segments
+		case !HasMeta(seg, mode):
+			for _, dir := range names {
+				name := join(dir, unescape(seg))
+				if info, err := fs.Stat(fsys, name); err == nil && (info.IsDir() || last && !dirOnly) {
+					next = append(next, name)
+				}
+			}
+		default:
+			for _, dir := range names {
+				entries, _ := fs.ReadDir(fsys, dir)
+				for _, e := range entries {
+					if match(seg, e.Name(), true, mode) && (e.IsDir() || last && !dirOnly) {
+						next = append(next, join(dir, e.Name()))
+					}
+				}
+			}
+		}
+		names = next
+	}
+	if len(names) == 0 {
+		return nil, ErrNoMatch
+	}
+	sort.Slice(names, func(i, j int) bool { return less(names[i], names[j]) })
+	for i, name := range names {
+		if dir != "." {
+			name = strings.TrimPrefix(name, dir+"/")
+		}
+		names[i] = root + prefix + name
+		if dirOnly {
+			names[i] += "/"
+		}
+	}
+	return names, nil
+}
+
+// recursive reports whether the pattern segment holds a "**".
+func recursive(seg string) bool {
+	for i := 0; i+1 < len(seg); i++ {
+		switch {
+		case seg[i] == '\\':
+			i++
+		case seg[i] == '*' && seg[i+1] == '*':
+			return true
+		}
+	}
+	return false
+}
+
+// walk returns the files below dir whose names relative to it match
+// pattern, skipping hidden directories.
+func walk(fsys fs.FS, dir, pattern string, dirOnly bool, mode Mode) (names []string) {
+	fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == dir {
+			return nil
+		}
+		rel := strings.TrimPrefix(name, dir+"/")
+		if dir == "." {
+			rel = name
+		}
+		if match(pattern, rel, true, mode) && (d.IsDir() || !dirOnly) {
+			names = append(names, name)
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return
+}
+
+// join returns the name of the file elem of dir.
+func join(dir, elem string) string {
+	if dir == "." {
+		return elem
+	}
+	return dir + "/" + elem
+}
+
+// unescape returns the text a pattern without wildcards matches.
+func unescape(pattern string) string {
+	if !strings.Contains(pattern, `\`) {
+		return pattern
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
+
+// less orders file names as fish does: letters regardless of case,
+// and runs of digits by their value.
+func less(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			m, n := i, j
+			for m < len(a) && isDigit(a[m]) {
+				m++
+			}
+			for n < len(b) && isDigit(b[n]) {
+				n++
+			}
+			x, y := strings.TrimLeft(a[i:m], "0"), strings.TrimLeft(b[j:n], "0")
+			if len(x) != len(y) {
+				return len(x) < len(y)
+			}
+			if x != y {
+				return x < y
+			}
+			i, j = m, n
+			continue
+		}
+		r, w := utf8.DecodeRuneInString(a[i:])
+		s, v := utf8.DecodeRuneInString(b[j:])
+		if r, s := unicode.ToLower(r), unicode.ToLower(s); r != s {
+			return r < s
+		}
+		i, j = i+w, j+v
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// Nullglob reports whether a wildcard matching nothing in an argument
+// of the command cmd expands to no argument, rather than being an
+// error. This is so for set, for and count.
+func Nullglob(cmd string) bool {
+	return cmd == "set" || cmd == "for" || cmd == "count"
+}
+
+// Pattern returns the pattern the word w matches file names with: its
+// wildcards, and its quoted and escaped text matching itself. An
+// unquoted "?" is kept, in a Lit as in a Glob, for the mode to decide
+// whether it is a wildcard. It
+// reports false if w expands a variable, substitutes a command, holds
+// a brace expansion or starts with "~".
+func Pattern(w *ast.Word) (string, bool) {
+	var b strings.Builder
+	for i, x := range w.Parts {
+		var s string
+		var err error
+		switch x := x.(type) {
+		case *ast.Lit:
+			if i == 0 && strings.HasPrefix(x.Value, "~") {
+				return "", false
+			}
+			if !globPattern(&b, x.Value) {
+				return "", false
+			}
+			continue
+		case *ast.Glob:
+			if i == 0 && strings.HasPrefix(x.Value, "~") {
+				return "", false
+			}
+			if !globPattern(&b, x.Value) {
+				return "", false
+			}
+			continue
+		case *ast.SingleQuoted:
+			s, err = quote.Unescape(x.Value, quote.SingleQuoted)
+		case *ast.DoubleQuoted:
+			var text strings.Builder
+			for _, part := range x.Parts {
+				lit, ok := part.(*ast.Lit)
+				if !ok {
+					return "", false
+				}
+				text.WriteString(lit.Value)
+			}
+			s, err = quote.Unescape(text.String(), quote.DoubleQuoted)
+		default:
+			return "", false
+		}
+		if err != nil {
+			return "", false
+		}
+		b.WriteString(escape(s))
+	}
+	return b.String(), true
+}
+
+// globPattern writes the pattern of the unquoted text s of a Glob,
+// and reports whether its escape sequences are valid.
+func globPattern(b *strings.Builder, s string) bool {
+	lit0 := 0 // start of the pending literal text
+	flush := func(i int) bool {
+		t, err := quote.Unescape(s[lit0:i], quote.Unquoted)
+		b.WriteString(escape(t))
+		return err == nil
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '*', '?':
+			if !flush(i) {
+				return false
+			}
+			b.WriteByte(s[i])
+			lit0 = i + 1
+		}
+	}
+	return flush(len(s))
+}
+
+// escape returns s with its wildcards and backslashes escaped.
+func escape(s string) string {
+	if !strings.ContainsAny(s, `*?\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '*' || c == '?' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Expand returns the file names the word w expands to as an argument
+// of the command cmd, after expanding its braces. The words without
+// wildcards expand to themselves. If a wildcard matches no file,
+// Expand returns ErrNoMatch, unless Nullglob(cmd) holds and it expands
+// to nothing. It returns ErrNotStatic if w does not have a pattern.
+func Expand(fsys fs.FS, cmd string, w *ast.Word, mode Mode) ([]string, error) {
+	var names []string
+	for _, w := range expand.Braces(w) {
+		pattern, ok := Pattern(w)
+		if !ok {
+			return nil, ErrNotStatic
+		}
+		matches, err := Glob(fsys, pattern, mode)
+		if err == ErrNoMatch && Nullglob(cmd) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+	return names, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package glob_test
+
+import (
+	"path"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/glob"
+	"github.com/hulo-io/fishparser/parser"
+)
+
+var fixture = fstest.MapFS{
+	"README.md":           {},
+	".bashrc":             {},
+	"conf.d/a.fish":       {},
+	"conf.d/b.fish":       {},
+	"conf.d/.hidden.fish": {},
+	"src/main.go":         {},
+	"src/cmd/x/x.go":      {},
+	"src/.git/config.go":  {},
+	"file2.txt":           {},
+	"file10.txt":          {},
+	"File1.txt":           {},
+	"what?":               {},
+	"star*":               {},
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		mode          glob.Mode
+		want          bool
+	}{
+		{"*.fish", "a.fish", 0, true},
+		{"*.fish", ".a.fish", 0, false},
+		{".*", ".bashrc", 0, true},
+		{"*", "a/b", 0, false},
+		{"**", "a/b", 0, true},
+		{"**.go", "a/b/c.go", 0, true},
+		{"**/*.go", "c.go", 0, true},
+		{"**/*.go", ".git/c.go", 0, false},
+		{"**/*.go", "a/.git/c.go", 0, false},
+		{"a?", "ab", 0, false},
+		{"a?", "a?", 0, true},
+		{"a?", "ab", glob.QMark, true},
+		{"?", "é", glob.QMark, true},
+		{"?bashrc", ".bashrc", glob.QMark, false},
+		{`\*`, "*", 0, true},
+		{`\*`, "a", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := glob.Match(tt.pattern, tt.name, tt.mode)
+		if err != nil || got != tt.want {
+			t.Errorf("Match(%q, %q, %d) = %v, %v, want %v", tt.pattern, tt.name, tt.mode, got, err, tt.want)
+		}
+	}
+	if _, err := glob.Match(`a\`, "a", 0); err != path.ErrBadPattern {
+		t.Errorf("got error %v for a trailing backslash", err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		mode    glob.Mode
+		want    []string
+	}{
+		{"conf.d/*.fish", 0, []string{"conf.d/a.fish", "conf.d/b.fish"}},
+		{"conf.d/.*", 0, []string{"conf.d/.hidden.fish"}},
+		{"*.txt", 0, []string{"File1.txt", "file2.txt", "file10.txt"}},
+		{"*/", 0, []string{"conf.d/", "src/"}},
+		{"**.go", 0, []string{"src/cmd/x/x.go", "src/main.go"}},
+		{"src/**/*.go", 0, []string{"src/cmd/x/x.go", "src/main.go"}},
+		{"*/x", 0, nil},
+		{"/src/*.go", 0, []string{"/src/main.go"}},
+		{"./*.txt", 0, []string{"./File1.txt", "./file2.txt", "./file10.txt"}},
+		{"./conf.d/*.fish", 0, []string{"./conf.d/a.fish", "./conf.d/b.fish"}},
+		{"src/./cmd/*/x.go", 0, []string{"src/./cmd/x/x.go"}},
+		{"./src/**.go", 0, []string{"./src/cmd/x/x.go", "./src/main.go"}},
+		{"no/such/*", 0, nil},
+		{"what?", glob.QMark, []string{"what?"}},
+		{`star\*`, 0, []string{"star*"}},
+		{"no/such/file", 0, []string{"no/such/file"}},
+	}
+	for _, tt := range tests {
+		got, err := glob.Glob(fixture, tt.pattern, tt.mode)
+		if tt.want == nil {
+			if err != glob.ErrNoMatch {
+				t.Errorf("Glob(%q) = %q, %v, want ErrNoMatch", tt.pattern, got, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%q) = %q, %v, want %q", tt.pattern, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+		err  error
+	}{
+		{"ls conf.d/*.fish", []string{"conf.d/a.fish", "conf.d/b.fish"}, nil},
+		{"ls ./conf.d/*.fish", []string{"./conf.d/a.fish", "./conf.d/b.fish"}, nil},
+		{"ls 'conf.d'/{a,b}.fish", []string{"conf.d/a.fish", "conf.d/b.fish"}, nil},
+		{"ls {conf.d,src}/*.go", nil, glob.ErrNoMatch},
+		{"ls '*.txt'", []string{"*.txt"}, nil},
+		{`ls what\?`, []string{"what?"}, nil},
+		{"ls *.none", nil, glob.ErrNoMatch},
+		{"count *.none", nil, nil},
+		{"set x *.none", nil, nil},
+		{"ls $dir/*", nil, glob.ErrNotStatic},
+		{"ls ~/*", nil, glob.ErrNotStatic},
+	}
+	for _, tt := range tests {
+		x, err := parser.ParseExpr(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		call := x.(*ast.CallExpr)
+		args := call.Recv
+		w := args[len(args)-1].(*ast.Word)
+		got, err := glob.Expand(fixture, call.Func.Name, w, 0)
+		if err != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.src, got, err, tt.want, tt.err)
+		}
+	}
+
+	// an unquoted "?" is a wildcard in the QMark mode only
+	for _, tt := range []struct {
+		src  string
+		mode glob.Mode
+		want []string
+	}{
+		{"ls file?.txt", 0, []string{"file?.txt"}},
+		{"ls file?.txt", glob.QMark, []string{"file2.txt"}},
+		{"ls 'file?'.txt", glob.QMark, []string{"file?.txt"}},
+		{`ls file\?.txt`, glob.QMark, []string{"file?.txt"}},
+		{"ls *?.txt", glob.QMark, []string{"File1.txt", "file2.txt", "file10.txt"}},
+	} {
+		x, err := parser.ParseExpr(tt.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		call := x.(*ast.CallExpr)
+		got, err := glob.Expand(fixture, call.Func.Name, call.Recv[0].(*ast.Word), tt.mode)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q in mode %d: got %q, %v, want %q", tt.src, tt.mode, got, err, tt.want)
+		}
+	}
+}
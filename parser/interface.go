@@ -0,0 +1,148 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package parser implements a parser for fish source files. Input may be
+// provided in a variety of forms (see the various Parse* functions); the
+// output is an abstract syntax tree (AST) representing the fish source.
+// The parser is invoked through one of the Parse* functions.
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/token"
+)
+
+// If src != nil, readSource converts src to a []byte if possible;
+// otherwise it returns an error. If src == nil, readSource returns
+// the result of reading the file specified by filename.
+func readSource(filename string, src any) ([]byte, error) {
+	if src != nil {
+		switch s := src.(type) {
+		case string:
+			return []byte(s), nil
+		case []byte:
+			return s, nil
+		case *bytes.Buffer:
+			// is io.Reader, but src is already available in []byte form
+			if s != nil {
+				return s.Bytes(), nil
+			}
+		case io.Reader:
+			return io.ReadAll(s)
+		}
+		return nil, errors.New("invalid source")
+	}
+	return os.ReadFile(filename)
+}
+
+// A Mode value is a set of flags (or 0).
+// They control the amount of source code parsed and other optional
+// parser functionality.
+type Mode uint
+
+const (
+	ParseComments  Mode = 1 << iota // parse comments and add them to AST
+	AllErrors                       // report all errors (not just the first 10 on different lines)
+	CaretRedirects                  // accept the "^" and "^^" stderr redirections of fish 2
+)
+
+// ParseFile parses the source code of a single fish source file and returns
+// the corresponding ast.File node. The source code may be provided via
+// the filename of the source file, or via the src parameter.
+//
+// If src != nil, ParseFile parses the source from src and the filename is
+// only used when recording position information. The type of the argument
+// for the src parameter must be string, []byte, or io.Reader.
+// If src == nil, ParseFile parses the file specified by filename.
+//
+// Position information is recorded in the file set fset, which must not be
+// nil.
+//
+// If the source couldn't be read, the returned AST is nil and the error
+// indicates the specific failure. If the source was read but syntax
+// errors were found, the result is a partial AST (with ast.Bad* nodes
+// representing the fragments of erroneous source code). Multiple errors
+// are returned via a scanner.ErrorList which is sorted by source position.
+//
+// The parser recovers at statement boundaries, so a missing "end" or a
+// malformed command does not hide the errors that follow it.
+func ParseFile(fset *token.FileSet, filename string, src any, mode Mode) (f *ast.File, err error) {
+	if fset == nil {
+		panic("parser.ParseFile: no token.FileSet provided (fset == nil)")
+	}
+
+	// get source
+	text, err := readSource(filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var p parser
+	defer func() {
+		if e := recover(); e != nil {
+			// resume same panic if it's not a bailout
+			if _, ok := e.(bailout); !ok {
+				panic(e)
+			}
+		}
+
+		// set result values
+		if f == nil {
+			// source is not a valid fish source file - satisfy
+			// ParseFile API and return a valid (but) empty *ast.File
+			f = &ast.File{}
+		}
+
+		p.errors.Sort()
+		err = p.errors.Err()
+	}()
+
+	// parse source
+	p.init(fset, filename, text, mode)
+	f = p.parseFile()
+
+	return
+}
+
+// ParseExpr is a convenience function for obtaining the AST of a single
+// job, such as a command, a pipeline or commands joined by "&&" and "||".
+// The position information recorded in the AST is undefined. The filename
+// used in error messages is the empty string.
+//
+// If syntax errors were found, the result is a partial AST (with ast.Bad*
+// nodes representing the fragments of erroneous source code). Multiple
+// errors are returned via a scanner.ErrorList which is sorted by source
+// position.
+func ParseExpr(x string) (expr ast.Expr, err error) {
+	fset := token.NewFileSet()
+
+	var p parser
+	defer func() {
+		if e := recover(); e != nil {
+			// resume same panic if it's not a bailout
+			if _, ok := e.(bailout); !ok {
+				panic(e)
+			}
+		}
+		p.errors.Sort()
+		err = p.errors.Err()
+	}()
+
+	p.init(fset, "", []byte(x), 0)
+	expr = p.parseJobConjunction()
+
+	// a trailing separator is permitted, anything else is not
+	if p.tok == token.SEMI {
+		p.next()
+	}
+	if p.tok != token.EOF {
+		p.errorExpected(p.pos, "end of input")
+	}
+	return
+}
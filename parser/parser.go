@@ -0,0 +1,1416 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/quote"
+	"github.com/hulo-io/fishparser/scanner"
+	"github.com/hulo-io/fishparser/token"
+)
+
+// The parser structure holds the parser's internal state.
+type parser struct {
+	file    *token.File
+	src     []byte
+	errors  scanner.ErrorList
+	scanner scanner.Scanner
+
+	// Options
+	mode Mode // parsing mode
+
+	// Comments
+	comments    []*ast.CommentGroup
+	leadComment *ast.CommentGroup // last lead comment
+	lineComment *ast.CommentGroup // last line comment
+
+	// Next token
+	pos token.Pos   // token position
+	tok token.Token // one token look-ahead
+	lit string      // token literal
+
+	// Nesting levels
+	loopLev int // loop nesting level, reset in function bodies and substitutions
+}
+
+func (p *parser) init(fset *token.FileSet, filename string, src []byte, mode Mode) {
+	p.file = fset.AddFile(filename, -1, len(src))
+	p.src = src
+	p.mode = mode
+	p.scanner.Init(p.file, src, p.errorAt, p.scanMode())
+
+	p.next()
+}
+
+// scanMode returns the scanner mode for the parsing mode.
+func (p *parser) scanMode() scanner.Mode {
+	var m scanner.Mode
+	if p.mode&ParseComments != 0 {
+		m |= scanner.ScanComments
+	}
+	if p.mode&CaretRedirects != 0 {
+		m |= scanner.CaretRedirects
+	}
+	return m
+}
+
+// ----------------------------------------------------------------------------
+// Parsing support
+
+// Advance to the next token.
+func (p *parser) next0() {
+	p.pos, p.tok, p.lit = p.scanner.Scan()
+}
+
+// Consume a comment and return it and the line on which it ends.
+func (p *parser) consumeComment() (comment *ast.Comment, endline int) {
+	endline = p.file.Line(p.pos)
+	comment = &ast.Comment{Hash: p.pos, Text: p.lit}
+	p.next0()
+	return
+}
+
+// Consume a group of adjacent comments, add it to the parser's
+// comments list, and return it together with the line at which
+// the last comment in the group ends. A non-comment token or n
+// empty lines terminate a comment group. A group spanning lines
+// also consumes the newlines that separate and follow its comments.
+func (p *parser) consumeCommentGroup(n int) (comments *ast.CommentGroup, endline int) {
+	var list []*ast.Comment
+	endline = p.file.Line(p.pos)
+	for p.tok == token.COMMENT && p.file.Line(p.pos) <= endline+n {
+		var comment *ast.Comment
+		comment, endline = p.consumeComment()
+		list = append(list, comment)
+		if n > 0 && p.tok == token.SEMI && p.lit == "\n" {
+			p.next0()
+		}
+	}
+
+	// add comment group to the comments list
+	comments = &ast.CommentGroup{List: list}
+	p.comments = append(p.comments, comments)
+
+	return
+}
+
+// Advance to the next non-comment token. In the process, collect
+// any comment groups encountered, and remember the last lead and
+// line comments.
+//
+// A lead comment is a comment group that starts and ends in a
+// line without any other tokens and that is followed by a non-comment
+// token on the line immediately after the comment group.
+//
+// A line comment is a comment group that follows a non-comment
+// token on the same line. Since a comment always runs to the end
+// of its line, a line comment is a single comment.
+//
+// Lead and line comments may be considered documentation that is
+// stored in the AST.
+func (p *parser) next() {
+	p.leadComment = nil
+	p.lineComment = nil
+	prev := p.pos
+	p.next0()
+
+	if p.tok == token.COMMENT {
+		var comment *ast.CommentGroup
+		var endline int
+
+		if p.file.Line(p.pos) == p.file.Line(prev) {
+			// The comment is on same line as the previous token; it
+			// cannot be a lead comment but may be a line comment.
+			// The newline ending it still terminates the statement.
+			comment, _ = p.consumeCommentGroup(0)
+			p.lineComment = comment
+		}
+
+		// consume successor comments, if any
+		endline = -1
+		for p.tok == token.COMMENT {
+			comment, endline = p.consumeCommentGroup(1)
+		}
+
+		if endline+1 == p.file.Line(p.pos) && p.tok != token.SEMI && p.tok != token.EOF {
+			// The next token is following on the line immediately after the
+			// comment group, thus the last comment group is a lead comment.
+			p.leadComment = comment
+		}
+	}
+}
+
+// skipNewlines skips the newlines permitted after a pipe or a
+// "&&"/"||" operator.
+func (p *parser) skipNewlines() {
+	for p.tok == token.SEMI && p.lit == "\n" {
+		p.next()
+	}
+}
+
+// A bailout panic is raised to indicate early termination.
+type bailout struct{}
+
+func (p *parser) error(pos token.Pos, msg string) {
+	p.errorAt(p.file.Position(pos), msg)
+}
+
+func (p *parser) errorAt(epos token.Position, msg string) {
+	// Recovery often reports the same token twice; keep the first message.
+	if n := len(p.errors); n > 0 && p.errors[n-1].Pos == epos {
+		return
+	}
+
+	// If AllErrors is not set, discard errors reported on the same line
+	// as the last recorded error and stop parsing if there are more than
+	// 10 errors.
+	if p.mode&AllErrors == 0 {
+		n := len(p.errors)
+		if n > 0 && p.errors[n-1].Pos.Line == epos.Line {
+			return // discard - likely a spurious error
+		}
+		if n > 10 {
+			panic(bailout{})
+		}
+	}
+
+	p.errors.Add(epos, msg)
+}
+
+// tokDesc describes the current token the way fish does in
+// its error messages.
+func (p *parser) tokDesc() string {
+	switch {
+	case p.tok == token.SEMI:
+		return "end of the statement"
+	case p.tok == token.EOF:
+		return "end of the input"
+	case p.tok == token.WORD:
+		return "a string"
+	case p.tok == token.BITOR || p.tok == token.AND_PIPE || p.tok == token.GT_PIPE:
+		return "a pipe"
+	case p.tok.IsRedirect():
+		return "a redirection"
+	case p.tok == token.BITAND:
+		return "a '&'"
+	case token.IsKeyword(string(p.tok)):
+		return "keyword '" + p.lit + "'"
+	}
+	return "'" + p.lit + "'"
+}
+
+func (p *parser) errorExpected(pos token.Pos, msg string) {
+	msg = "expected " + msg
+	if pos == p.pos {
+		// the error happened at the current position;
+		// make the error message more specific
+		msg += ", but found " + p.tokDesc()
+	}
+	p.error(pos, msg)
+}
+
+func (p *parser) expect(tok token.Token) token.Pos {
+	pos := p.pos
+	if p.tok != tok {
+		p.errorExpected(pos, "'"+string(tok)+"'")
+	}
+	p.next() // make progress
+	return pos
+}
+
+// advance consumes tokens up to and including the next statement
+// separator. It stops before "end" so that the enclosing block can
+// still be closed.
+func (p *parser) advance() {
+	for p.tok != token.EOF && p.tok != token.END {
+		if p.tok == token.SEMI {
+			p.next()
+			return
+		}
+		p.next()
+	}
+}
+
+// expectSemi consumes the ";" or newline that terminates a statement.
+// Anything else up to the next separator is reported and skipped.
+func (p *parser) expectSemi() {
+	switch p.tok {
+	case token.SEMI:
+		p.next()
+	case token.EOF:
+		// the last statement needs no terminator
+	case token.END:
+		// only reachable after an error, such as a pipe into "end";
+		// leave it to close the enclosing block
+	default:
+		p.errorExpected(p.pos, "end of the statement")
+		p.advance()
+	}
+}
+
+// expectEnd consumes the "end" of the block opened by the keyword at
+// pos, which is described by what.
+func (p *parser) expectEnd(pos token.Pos, what string) token.Pos {
+	if p.tok != token.END {
+		p.error(pos, "Missing end to balance this "+what)
+		return token.NoPos
+	}
+	end := p.pos
+	p.next()
+	return end
+}
+
+// ----------------------------------------------------------------------------
+// Words
+
+// parseWord splits the raw text of a WORD token into its unquoted,
+// quoted, expanded and substituted parts.
+func (p *parser) parseWord() ast.Expr {
+	if p.tok != token.WORD {
+		p.errorExpected(p.pos, "a string")
+		return &ast.BadExpr{From: p.pos, To: p.pos}
+	}
+	pos, lit := p.pos, p.lit
+	p.next()
+	return p.wordParts(pos, lit)
+}
+
+// wordParts splits the raw text lit of a word at pos into its parts.
+func (p *parser) wordParts(pos token.Pos, lit string) *ast.Word {
+	w := &ast.Word{}
+	lit0 := 0 // start of the pending unquoted run
+	flush := func(i int) {
+		switch {
+		case i == lit0:
+		case hasWildcard(lit[lit0:i]):
+			w.Parts = append(w.Parts, &ast.Glob{ValuePos: pos + token.Pos(lit0), Value: lit[lit0:i]})
+		default:
+			w.Parts = append(w.Parts, &ast.Lit{ValuePos: pos + token.Pos(lit0), Value: lit[lit0:i]})
+		}
+	}
+	for i := 0; i < len(lit); {
+		switch c := lit[i]; {
+		case c == '\\':
+			i += 2
+		case c == '\'':
+			flush(i)
+			j := skipQuoted(lit, i)
+			w.Parts = append(w.Parts, &ast.SingleQuoted{
+				Lquote: pos + token.Pos(i),
+				Value:  lit[i+1 : closingQuote(lit, i, j)],
+				Rquote: pos + token.Pos(j-1),
+			})
+			i, lit0 = j, j
+		case c == '"':
+			flush(i)
+			j := skipQuoted(lit, i)
+			k := closingQuote(lit, i, j)
+			w.Parts = append(w.Parts, &ast.DoubleQuoted{
+				Lquote: pos + token.Pos(i),
+				Parts:  p.quotedParts(pos+token.Pos(i+1), lit[i+1:k]),
+				Rquote: pos + token.Pos(j-1),
+			})
+			i, lit0 = j, j
+		case c == '(' || c == '$' && i+1 < len(lit) && lit[i+1] == '(':
+			flush(i)
+			x, j := p.parseCmdSubst(pos, lit, i)
+			w.Parts = append(w.Parts, x)
+			i, lit0 = j, j
+		case c == '$':
+			flush(i)
+			x, j := p.parseVarExpansion(pos, lit, i)
+			w.Parts = append(w.Parts, x)
+			i, lit0 = j, j
+		case c == '{':
+			if x, j := p.parseBraceExp(pos, lit, i); x != nil {
+				flush(i)
+				w.Parts = append(w.Parts, x)
+				i, lit0 = j, j
+				break
+			}
+			if i+1 == len(lit) || lit[i+1] != '$' {
+				i++
+				break
+			}
+			// {$var} delimits a variable from the text around it
+			j := skipBraces(lit, i)
+			x, k := p.parseVarExpansion(pos, lit, i+1)
+			if k+1 != j || lit[k] != '}' {
+				i++
+				break
+			}
+			flush(i)
+			x.Lbrace, x.Rbrace = pos+token.Pos(i), pos+token.Pos(k)
+			w.Parts = append(w.Parts, x)
+			i, lit0 = j, j
+		default:
+			i++
+		}
+	}
+	flush(len(lit))
+
+	if len(w.Parts) == 0 {
+		w.Parts = []ast.Expr{&ast.Lit{ValuePos: pos}}
+	}
+	return w
+}
+
+// hasWildcard reports whether the unquoted text s holds a "*" that is
+// not escaped. A "?" is left in a Lit: it is only a wildcard with the
+// qmark-noglob feature of fish off, which the glob package leaves to
+// its Mode.
+func hasWildcard(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '*':
+			return true
+		}
+	}
+	return false
+}
+
+// closingQuote returns the index of the quote closing the string that
+// starts at s[i] and ends before s[j], or j if it is not terminated.
+func closingQuote(s string, i, j int) int {
+	if j-i >= 2 && s[j-1] == s[i] {
+		return j - 1
+	}
+	return j
+}
+
+// quotedParts splits the text s between double quotes at pos into
+// literal text, variable expansions and $( ) command substitutions.
+// A "$" that starts neither is literal text.
+func (p *parser) quotedParts(pos token.Pos, s string) (parts []ast.Expr) {
+	lit0 := 0 // start of the pending literal text
+	flush := func(i int) {
+		if i > lit0 {
+			parts = append(parts, &ast.Lit{ValuePos: pos + token.Pos(lit0), Value: s[lit0:i]})
+		}
+	}
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '\\':
+			i += 2
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '(':
+			flush(i)
+			x, j := p.parseCmdSubst(pos, s, i)
+			x.Quoted = true
+			parts = append(parts, x)
+			i, lit0 = j, j
+		case s[i] == '$' && i+1 < len(s) && (s[i+1] == '$' || isNameChar(s[i+1])):
+			flush(i)
+			x, j := p.parseVarExpansion(pos, s, i)
+			parts = append(parts, x)
+			i, lit0 = j, j
+		default:
+			i++
+		}
+	}
+	flush(len(s))
+	return
+}
+
+// parseCmdSubst parses the command substitution starting with the
+// "(" or "$(" at lit[i] of the word lit at pos, and returns it with
+// the index just past it.
+func (p *parser) parseCmdSubst(pos token.Pos, lit string, i int) (*ast.CmdSubst, int) {
+	x := &ast.CmdSubst{}
+	if lit[i] == '$' {
+		x.Dollar = pos + token.Pos(i)
+		i++
+	}
+	j := skipSubst(lit, i)
+	x.Lparen = pos + token.Pos(i)
+	x.Rparen = pos + token.Pos(j-1)
+	x.X = p.parseSubst(x.Lparen+1, x.Rparen)
+	return x, j
+}
+
+// isNameChar reports whether c may appear in a variable name.
+func isNameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// parseVarExpansion parses the variable expansion starting with the
+// "$" at lit[i] of the word lit at pos. It returns the expansion and
+// the index just past it. Index brackets following the name belong
+// to the innermost expansion of "$$name", as in fish.
+func (p *parser) parseVarExpansion(pos token.Pos, lit string, i int) (*ast.VarExpansion, int) {
+	x := &ast.VarExpansion{Dollar: pos + token.Pos(i)}
+	j := i + 1
+	if j < len(lit) && lit[j] == '$' {
+		inner, k := p.parseVarExpansion(pos, lit, j)
+		x.X = inner
+		return x, k
+	}
+	for j < len(lit) && isNameChar(lit[j]) {
+		j++
+	}
+	if j == i+1 {
+		p.error(x.Dollar, "expected a variable name after this $")
+		x.X = &ast.BadExpr{From: x.Dollar + 1, To: x.Dollar + 1}
+		return x, j
+	}
+	x.X = &ast.Ident{NamePos: pos + token.Pos(i+1), Name: lit[i+1 : j]}
+	for j < len(lit) && lit[j] == '[' {
+		var index *ast.IndexExpr
+		index, j = p.parseIndex(pos, lit, j)
+		x.Index = append(x.Index, index)
+	}
+	return x, j
+}
+
+// parseIndex parses the list index starting with the "[" at lit[i]
+// of the word lit at pos, and returns it with the index just past it.
+// The indices are separated by blanks; each is a word or a range.
+func (p *parser) parseIndex(pos token.Pos, lit string, i int) (*ast.IndexExpr, int) {
+	x := &ast.IndexExpr{Lbrack: pos + token.Pos(i)}
+	j := i + 1
+	for j < len(lit) && lit[j] != ']' {
+		if isBlank(lit[j]) {
+			j++
+			continue
+		}
+		k := j
+		dots := -1 // offset of ".." in the index
+		depth := 0 // depth of nested index brackets, as in "$a[$b[1]]"
+		for k < len(lit) && (depth > 0 || lit[k] != ']' && !isBlank(lit[k])) {
+			switch lit[k] {
+			case '[':
+				depth++
+			case ']':
+				depth--
+			case '\\':
+				k++
+			case '\'', '"':
+				k = skipQuoted(lit, k) - 1
+			case '(':
+				k = skipSubst(lit, k) - 1
+			case '.':
+				if dots < 0 && depth == 0 && k+1 < len(lit) && lit[k+1] == '.' {
+					dots = k
+					k++
+				}
+			}
+			k++
+		}
+		if k > len(lit) {
+			k = len(lit) // a trailing backslash
+		}
+		if dots < 0 {
+			x.List = append(x.List, p.wordParts(pos+token.Pos(j), lit[j:k]))
+		} else {
+			r := &ast.RangeExpr{Dots: pos + token.Pos(dots)}
+			if dots > j {
+				r.Low = p.wordParts(pos+token.Pos(j), lit[j:dots])
+			}
+			if dots+2 < k {
+				r.High = p.wordParts(pos+token.Pos(dots+2), lit[dots+2:k])
+			}
+			x.List = append(x.List, r)
+		}
+		j = k
+	}
+	if j < len(lit) {
+		x.Rbrack = pos + token.Pos(j)
+		j++
+	} else {
+		// the scanner reported the missing "]"
+		x.Rbrack = pos + token.Pos(len(lit))
+	}
+	return x, j
+}
+
+// parseBraceExp parses the brace expansion starting with the "{" at
+// lit[i] of the word lit at pos, and returns it with the index just
+// past it. It returns nil if the braces are not closed or have no
+// comma between them, which makes them literal text.
+func (p *parser) parseBraceExp(pos token.Pos, lit string, i int) (*ast.BraceExp, int) {
+	x := &ast.BraceExp{Lbrace: pos + token.Pos(i)}
+	elem := i + 1 // start of the current item
+	item := func(end int) {
+		start := elem
+		for start < end && isBlank(lit[start]) {
+			start++
+		}
+		for end > start && isBlank(lit[end-1]) && lit[end-2] != '\\' {
+			end--
+		}
+		x.Elems = append(x.Elems, p.wordParts(pos+token.Pos(start), lit[start:end]))
+	}
+	depth := 0
+	for j := i + 1; j < len(lit); j++ {
+		switch lit[j] {
+		case '\\':
+			j++
+		case '\'', '"':
+			j = skipQuoted(lit, j) - 1
+		case '(':
+			j = skipSubst(lit, j) - 1
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+				break
+			}
+			if len(x.Commas) == 0 {
+				return nil, 0
+			}
+			item(j)
+			x.Rbrace = pos + token.Pos(j)
+			return x, j + 1
+		case ',':
+			if depth == 0 {
+				item(j)
+				x.Commas = append(x.Commas, pos+token.Pos(j))
+				elem = j + 1
+			}
+		}
+	}
+	return nil, 0
+}
+
+// isBlank reports whether c is a blank, which braces and index
+// brackets may hold between their items.
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// skipBraces returns the index just past the brace expansion whose
+// opening brace is s[i].
+func skipBraces(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\'', '"':
+			i = skipQuoted(s, i) - 1
+		case '(':
+			i = skipSubst(s, i) - 1
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// skipQuoted returns the index just past the quoted string starting at s[i].
+func skipQuoted(s string, i int) int {
+	q := s[i]
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case q:
+			return i + 1
+		case '$':
+			if q == '"' && i+1 < len(s) && s[i+1] == '(' {
+				i = skipSubst(s, i+1) - 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// skipSubst returns the index just past the command substitution
+// whose opening parenthesis is s[i].
+func skipSubst(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\'', '"':
+			i = skipQuoted(s, i) - 1
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// parseSubst parses the body of a command substitution between the
+// positions start and end. A body holding a single job is returned
+// as that job; any other body is returned as a CmdGroup.
+func (p *parser) parseSubst(start, end token.Pos) ast.Expr {
+	s, pos, tok, lit, loopLev := p.scanner, p.pos, p.tok, p.lit, p.loopLev
+	lead, line := p.leadComment, p.lineComment
+	p.scanner.InitRange(p.file, p.src, p.file.Offset(start), p.file.Offset(end), p.errorAt, p.scanMode()&^scanner.ScanComments)
+	p.loopLev = 0
+	p.next()
+	list := p.parseStmtList()
+	p.scanner, p.pos, p.tok, p.lit, p.loopLev = s, pos, tok, lit, loopLev
+	p.leadComment, p.lineComment = lead, line
+
+	if len(list) == 1 {
+		if s, ok := list[0].(*ast.ExprStmt); ok {
+			return s.X
+		}
+	}
+	return &ast.CmdGroup{List: list}
+}
+
+// ----------------------------------------------------------------------------
+// Commands and jobs
+
+// fd returns the file descriptor written before the current
+// redirection or pipe operator, which the scanner keeps in the
+// literal as in "2>>" or "2>|", or the default one of the operator.
+func (p *parser) fd() int {
+	fd := p.lit[:len(p.lit)-len(p.tok)]
+	if fd == "" {
+		return p.tok.DefaultFd()
+	}
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		p.error(p.pos, "invalid file descriptor "+fd)
+	}
+	return n
+}
+
+// parseRedirect parses a redirection and its target.
+func (p *parser) parseRedirect() *ast.Redirect {
+	r := &ast.Redirect{OpPos: p.pos, Fd: p.fd(), Op: p.tok}
+	p.next()
+	r.Word = p.parseWord()
+	return r
+}
+
+// parseRedirs parses the redirections following the "end" of a block.
+func (p *parser) parseRedirs() (list []*ast.Redirect) {
+	for p.tok.IsRedirect() {
+		list = append(list, p.parseRedirect())
+	}
+	return
+}
+
+// parseCommand parses a simple command with its arguments and
+// redirections, or a statement run as a command.
+func (p *parser) parseCommand() ast.Expr {
+	switch p.tok {
+	case token.WORD, token.RETURN:
+	case token.BEGIN, token.IF, token.WHILE, token.FOR, token.SWITCH,
+		token.BREAK, token.CONTINUE:
+		return &ast.StmtExpr{Stmt: p.parseStmtCommand()}
+	default:
+		p.errorExpected(p.pos, "a command")
+		return &ast.BadExpr{From: p.pos, To: p.pos}
+	}
+	call := &ast.CallExpr{Func: &ast.Ident{NamePos: p.pos, Name: p.lit}}
+	p.next()
+
+	for {
+		switch {
+		case p.tok == token.WORD:
+			call.Recv = append(call.Recv, p.parseWord())
+			continue
+		case p.tok.IsRedirect():
+			call.Redirs = append(call.Redirs, p.parseRedirect())
+			continue
+		}
+		break
+	}
+	switch call.Func.Name {
+	case "status":
+		if x := statusCall(call); x != nil {
+			return x
+		}
+	case token.RETURN:
+		if len(call.Recv) <= 1 {
+			ret := &ast.ReturnStmt{Return: call.Func.NamePos, Redirs: call.Redirs}
+			if len(call.Recv) > 0 {
+				ret.X = call.Recv[0]
+			}
+			return &ast.StmtExpr{Stmt: ret}
+		}
+	case "exit":
+		if len(call.Recv) <= 1 {
+			exit := &ast.ExitStmt{Exit: call.Func.NamePos, Redirs: call.Redirs}
+			if len(call.Recv) > 0 {
+				exit.X = call.Recv[0]
+			}
+			return &ast.StmtExpr{Stmt: exit}
+		}
+	}
+	return call
+}
+
+// parseStmtCommand parses a block statement, or a break or continue
+// statement, as the command of a job.
+func (p *parser) parseStmtCommand() ast.Stmt {
+	switch p.tok {
+	case token.BEGIN:
+		return p.parseBeginStmt()
+	case token.IF:
+		return p.parseIfStmt()
+	case token.WHILE:
+		return p.parseWhileStmt()
+	case token.FOR:
+		return p.parseForStmt()
+	case token.SWITCH:
+		return p.parseSwitchStmt()
+	}
+	pos := p.pos
+	if p.loopLev == 0 {
+		p.error(pos, "'"+p.lit+"' while not inside of loop")
+	}
+	tok := p.tok
+	p.next()
+	if tok == token.BREAK {
+		return &ast.BreakStmt{Break: pos}
+	}
+	return &ast.ContinueStmt{Continue: pos}
+}
+
+// statusCall returns call as a StatusCall if its first argument is a
+// known subcommand of the status builtin, and nil otherwise.
+func statusCall(call *ast.CallExpr) *ast.StatusCall {
+	if len(call.Recv) == 0 {
+		return nil
+	}
+	w, ok := call.Recv[0].(*ast.Word)
+	if !ok || len(w.Parts) != 1 {
+		return nil
+	}
+	lit, ok := w.Parts[0].(*ast.Lit)
+	if !ok || ast.StatusSubcommand(lit.Value) == "" {
+		return nil
+	}
+	return &ast.StatusCall{
+		Status: call.Func.NamePos,
+		Sub:    &ast.Ident{NamePos: lit.ValuePos, Name: lit.Value},
+		Args:   call.Recv[1:],
+		Redirs: call.Redirs,
+	}
+}
+
+// parsePipe parses a pipe operator.
+func (p *parser) parsePipe() *ast.Pipe {
+	pipe := &ast.Pipe{OpPos: p.pos, Fd: p.fd(), Op: p.tok}
+	p.next()
+	return pipe
+}
+
+// parsePipeline parses commands joined by "|", "&|" or "2>|". A
+// single command is returned as is.
+func (p *parser) parsePipeline() ast.Expr {
+	x := p.parseCommand()
+	if p.tok != token.BITOR && p.tok != token.AND_PIPE && p.tok != token.GT_PIPE {
+		return x
+	}
+	pl := &ast.Pipeline{Cmds: []ast.Expr{x}}
+	for p.tok == token.BITOR || p.tok == token.AND_PIPE || p.tok == token.GT_PIPE {
+		pl.Pipes = append(pl.Pipes, p.parsePipe())
+		p.skipNewlines()
+		pl.Cmds = append(pl.Cmds, p.parseCommand())
+	}
+	return pl
+}
+
+// parseJob parses a pipeline, optionally negated with "not" or "!"
+// and optionally followed by the background operator "&". A pipeline
+// that is neither is returned as is.
+func (p *parser) parseJob() ast.Expr {
+	if p.tok == token.NOT_KW || p.tok == token.BITNOT {
+		j := &ast.Job{NotPos: p.pos, NotTok: p.tok}
+		p.next()
+		j.X = p.parseJob()
+		if inner, ok := j.X.(*ast.Job); ok && inner.Amp.IsValid() {
+			// the "&" applies to the outermost job
+			j.Amp, inner.Amp = inner.Amp, token.NoPos
+		}
+		return j
+	}
+	x := p.parsePipeline()
+	if p.tok == token.BITAND {
+		j := &ast.Job{X: x, Amp: p.pos}
+		p.next()
+		return j
+	}
+	return x
+}
+
+// parseJobConjunction parses jobs joined by "&&" and "||", optionally
+// decorated with the "and" or "or" keyword. A single undecorated job
+// is returned as is.
+func (p *parser) parseJobConjunction() ast.Expr {
+	c := &ast.JobConjunction{}
+	if p.tok == token.AND_KW || p.tok == token.OR_KW {
+		c.DecPos, c.DecTok = p.pos, p.tok
+		p.next()
+	}
+	c.Jobs = []ast.Expr{p.parseJob()}
+	for (p.tok == token.AND || p.tok == token.OR) && !isBackground(c.Jobs[len(c.Jobs)-1]) {
+		c.OpPos = append(c.OpPos, p.pos)
+		c.Ops = append(c.Ops, p.tok)
+		p.next()
+		p.skipNewlines()
+		c.Jobs = append(c.Jobs, p.parseJob())
+	}
+	if !c.DecPos.IsValid() && len(c.Jobs) == 1 {
+		return c.Jobs[0]
+	}
+	return c
+}
+
+// parseCond parses the condition of an if or while statement and the
+// ";" or newline ending it: a job or begin block, continued by the jobs
+// decorated with "and" or "or" that follow it. A single job is
+// returned as is.
+func (p *parser) parseCond() ast.Expr {
+	var list []ast.Stmt
+	x := p.parseJobConjunction()
+	if b := beginStmt(x); b != nil {
+		list = append(list, b)
+	} else {
+		list = append(list, &ast.ExprStmt{X: x})
+	}
+	for {
+		if p.tok != token.SEMI {
+			p.expectSemi()
+			break
+		}
+		for p.tok == token.SEMI {
+			p.next()
+		}
+		if p.tok != token.AND_KW && p.tok != token.OR_KW {
+			break
+		}
+		list = append(list, &ast.ExprStmt{X: p.parseJobConjunction()})
+	}
+	if s, ok := list[0].(*ast.ExprStmt); ok && len(list) == 1 {
+		return s.X
+	}
+	return &ast.JobList{List: list}
+}
+
+// beginStmt returns the begin block run by the job x, if it runs
+// nothing else, and nil otherwise.
+func beginStmt(x ast.Expr) *ast.BeginStmt {
+	if x, ok := x.(*ast.StmtExpr); ok {
+		b, _ := x.Stmt.(*ast.BeginStmt)
+		return b
+	}
+	return nil
+}
+
+// isBackground reports whether the job x ends with "&".
+func isBackground(x ast.Expr) bool {
+	switch x := x.(type) {
+	case *ast.Job:
+		return x.Amp.IsValid()
+	case *ast.JobConjunction:
+		return isBackground(x.Jobs[len(x.Jobs)-1])
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Statements
+
+// parseStmtList parses statements up to the end of input or one of the
+// block terminators in terms. Other block terminators are reported as
+// misplaced and skipped.
+func (p *parser) parseStmtList(terms ...token.Token) (list []ast.Stmt) {
+	for {
+		switch p.tok {
+		case token.SEMI:
+			p.next()
+			continue
+		case token.EOF:
+			return
+		}
+		for _, tok := range terms {
+			if p.tok == tok {
+				return
+			}
+		}
+		list = append(list, p.parseStmt())
+	}
+}
+
+func (p *parser) parseBody(terms ...token.Token) *ast.BlockStmt {
+	return &ast.BlockStmt{Tok: token.NONE, List: p.parseStmtList(terms...)}
+}
+
+// parseLoopBody parses the body of a for or while loop.
+func (p *parser) parseLoopBody() *ast.BlockStmt {
+	p.loopLev++
+	body := p.parseBody(token.END)
+	p.loopLev--
+	return body
+}
+
+func (p *parser) parseStmt() (s ast.Stmt) {
+	pos, doc := p.pos, p.leadComment
+	switch p.tok {
+	case token.FUNCTION:
+		s = &ast.DeclStmt{Decl: p.parseFuncDecl()}
+	case token.END:
+		p.error(pos, "'end' outside of a block")
+		p.next()
+		s = &ast.BadStmt{From: pos, To: p.pos}
+	case token.ELSE:
+		p.error(pos, "'else' builtin not inside of if block")
+		p.advance()
+		return &ast.BadStmt{From: pos, To: p.pos}
+	case token.CASE:
+		p.error(pos, "'case' builtin not inside of switch block")
+		p.advance()
+		return &ast.BadStmt{From: pos, To: p.pos}
+	default:
+		x := p.parseJobConjunction()
+		if call, ok := x.(*ast.CallExpr); ok && call.Func.Name == "set" {
+			if set := p.setStmt(call); set != nil {
+				s = set
+				break
+			}
+		}
+		if x, ok := x.(*ast.StmtExpr); ok {
+			// a statement that is the whole job
+			s = x.Stmt
+			setDoc(s, doc)
+			break
+		}
+		s = &ast.ExprStmt{X: x}
+		if isBackground(x) {
+			// "&" terminates the statement
+			return
+		}
+	}
+	p.expectSemi()
+	return
+}
+
+// setDoc sets the doc comment of the block statement s.
+func setDoc(s ast.Stmt, doc *ast.CommentGroup) {
+	switch s := s.(type) {
+	case *ast.BeginStmt:
+		s.Doc = doc
+	case *ast.IfStmt:
+		s.Doc = doc
+	case *ast.WhileStmt:
+		s.Doc = doc
+	case *ast.ForeachStmt:
+		s.Doc = doc
+	case *ast.SwitchStmt:
+		s.Doc = doc
+	}
+}
+
+func (p *parser) parseBeginStmt() *ast.BeginStmt {
+	pos := p.expect(token.BEGIN)
+	body := p.parseBody(token.END)
+	end := p.expectEnd(pos, "begin")
+	return &ast.BeginStmt{Begin: pos, Body: body, EndPos: end, Redirs: p.parseRedirs()}
+}
+
+// setLongOptions maps the long options of set to the equivalent
+// short ones. The long options "--path" and "--unpath" have none.
+var setLongOptions = map[string]rune{
+	"local": 'l', "function": 'f', "global": 'g', "universal": 'U',
+	"export": 'x', "unexport": 'u',
+	"append": 'a', "prepend": 'p', "erase": 'e', "query": 'q',
+}
+
+var (
+	setScopes = map[rune]ast.VarScope{'l': ast.ScopeLocal, 'f': ast.ScopeFunction, 'g': ast.ScopeGlobal, 'U': ast.ScopeUniversal}
+	setOps    = map[rune]ast.SetOp{'a': ast.SetAppend, 'p': ast.SetPrepend, 'e': ast.SetErase, 'q': ast.SetQuery}
+)
+
+// setOption applies the short option c of set to s. It reports
+// false if c is not known or conflicts with an earlier option.
+func setOption(s *ast.SetStmt, c rune) bool {
+	if scope, ok := setScopes[c]; ok {
+		if s.Scope != ast.ScopeDefault && s.Scope != scope {
+			return false
+		}
+		s.Scope = scope
+		return true
+	}
+	if op, ok := setOps[c]; ok {
+		if s.Op != ast.SetAssign && s.Op != op {
+			return false
+		}
+		s.Op = op
+		return true
+	}
+	switch c {
+	case 'x':
+		s.Export = true
+		return !s.Unexport
+	case 'u':
+		s.Unexport = true
+		return !s.Export
+	}
+	return false
+}
+
+// setStmt returns the set command call as a SetStmt, or nil if it
+// lists variables, has options that conflict or are not known, or
+// names a variable that is not a literal.
+func (p *parser) setStmt(call *ast.CallExpr) *ast.SetStmt {
+	s := &ast.SetStmt{Set: call.Func.NamePos, Redirs: call.Redirs}
+	args := call.Recv
+	for len(args) > 0 {
+		opt, ok := p.literal(args[0])
+		if !ok || !strings.HasPrefix(opt, "-") {
+			break
+		}
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		if name, ok := strings.CutPrefix(opt, "--"); ok {
+			switch {
+			case name == "path":
+				s.Path = true
+			case name == "unpath":
+				s.Unpath = true
+			case setLongOptions[name] == 0 || !setOption(s, setLongOptions[name]):
+				return nil
+			}
+			continue
+		}
+		for _, c := range opt[1:] {
+			if !setOption(s, c) {
+				return nil
+			}
+		}
+	}
+	if s.Path && s.Unpath {
+		return nil
+	}
+
+	nvars := 1
+	if s.Op == ast.SetErase || s.Op == ast.SetQuery {
+		nvars = len(args)
+	}
+	if len(args) < nvars || nvars == 0 {
+		return nil // a listing, or an error fish reports when it runs
+	}
+	for _, arg := range args[:nvars] {
+		v := p.varRef(arg)
+		if v == nil {
+			return nil
+		}
+		s.Vars = append(s.Vars, v)
+	}
+	if len(args) > nvars {
+		s.Values = args[nvars:]
+	}
+	return s
+}
+
+// literal returns the string the word x denotes, as fish passes it to
+// a command, if it is made only of literal and quoted text.
+func (p *parser) literal(x ast.Expr) (string, bool) {
+	if _, ok := x.(*ast.Word); !ok {
+		return "", false
+	}
+	s, err := quote.Unquote(string(p.src[p.file.Offset(x.Pos()):p.file.Offset(x.End())]))
+	return s, err == nil
+}
+
+// varRef returns the literal variable name x, with its optional
+// index, as a VarRef, or nil if x is not one.
+func (p *parser) varRef(x ast.Expr) *ast.VarRef {
+	pos := x.Pos()
+	lit := string(p.src[p.file.Offset(pos):p.file.Offset(x.End())])
+	name, _, ok := strings.Cut(lit, "[")
+	if !isVariableName(name) {
+		return nil
+	}
+	v := &ast.VarRef{Name: &ast.Ident{NamePos: pos, Name: name}}
+	if !ok {
+		return v
+	}
+	index, end := p.parseIndex(pos, lit, len(name))
+	if end != len(lit) {
+		return nil // text follows the index
+	}
+	v.Index = index
+	return v
+}
+
+func (p *parser) parseIfStmt() *ast.IfStmt {
+	pos := p.expect(token.IF)
+	cond := p.parseCond()
+	s := &ast.IfStmt{If: pos, Cond: cond, Body: p.parseBody(token.END, token.ELSE)}
+
+	for p.tok == token.ELSE {
+		doc, elsePos := p.leadComment, p.pos
+		p.next()
+		if p.tok == token.IF {
+			c := &ast.ElseIfClause{Doc: doc, Else: elsePos, If: p.pos}
+			p.next()
+			c.Cond = p.parseCond()
+			c.Body = p.parseBody(token.END, token.ELSE)
+			s.Elif = append(s.Elif, c)
+			continue
+		}
+		p.expectSemi()
+		s.Else = &ast.ElseClause{Doc: doc, Else: elsePos, Body: p.parseBody(token.END)}
+		break
+	}
+
+	s.EndPos = p.expectEnd(pos, "if statement")
+	s.Redirs = p.parseRedirs()
+	return s
+}
+
+func (p *parser) parseWhileStmt() *ast.WhileStmt {
+	pos := p.expect(token.WHILE)
+	cond := p.parseCond()
+	body := p.parseLoopBody()
+	end := p.expectEnd(pos, "while loop")
+	return &ast.WhileStmt{While: pos, Cond: cond, Body: body, EndPos: end, Redirs: p.parseRedirs()}
+}
+
+// isVariableName reports whether name is a valid fish variable name.
+func isVariableName(name string) bool {
+	for _, c := range name {
+		if !(c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+func (p *parser) parseForStmt() *ast.ForeachStmt {
+	s := &ast.ForeachStmt{For: p.expect(token.FOR)}
+	if p.tok == token.WORD {
+		if !isVariableName(p.lit) {
+			p.error(p.pos, "for: '"+p.lit+"' is not a valid variable name")
+		}
+		s.Elem = &ast.Ident{NamePos: p.pos, Name: p.lit}
+		p.next()
+	} else {
+		p.errorExpected(p.pos, "a variable name")
+		s.Elem = &ast.BadExpr{From: p.pos, To: p.pos}
+	}
+
+	if p.tok == token.WORD && p.lit == token.IN {
+		s.In = p.pos
+		p.next()
+	} else {
+		p.errorExpected(p.pos, "'in'")
+	}
+
+	for p.tok == token.WORD {
+		s.Group = append(s.Group, p.parseWord())
+	}
+	p.expectSemi()
+
+	s.Body = p.parseLoopBody()
+	s.EndPos = p.expectEnd(s.For, "for loop")
+	s.Redirs = p.parseRedirs()
+	return s
+}
+
+func (p *parser) parseSwitchStmt() *ast.SwitchStmt {
+	s := &ast.SwitchStmt{Switch: p.expect(token.SWITCH)}
+	var args []ast.Expr
+	for p.tok == token.WORD {
+		args = append(args, p.parseWord())
+	}
+	if len(args) != 1 {
+		p.error(s.Switch, fmt.Sprintf("switch: expected exactly one argument, got %d", len(args)))
+	}
+	if len(args) > 0 {
+		s.Value = args[0]
+	} else {
+		s.Value = &ast.BadExpr{From: p.pos, To: p.pos}
+	}
+	p.expectSemi()
+
+	for {
+		switch p.tok {
+		case token.SEMI:
+			p.next()
+			continue
+		case token.CASE:
+			s.Cases = append(s.Cases, p.parseCaseClause())
+			continue
+		case token.END, token.EOF:
+		default:
+			p.errorExpected(p.pos, "'case'")
+			p.parseStmt() // skip it
+			continue
+		}
+		break
+	}
+
+	s.EndPos = p.expectEnd(s.Switch, "switch statement")
+	s.Redirs = p.parseRedirs()
+	return s
+}
+
+func (p *parser) parseCaseClause() *ast.CaseClause {
+	doc := p.leadComment
+	c := &ast.CaseClause{Doc: doc, Case: p.expect(token.CASE)}
+	for p.tok == token.WORD {
+		c.Patterns = append(c.Patterns, p.parseWord())
+	}
+	p.expectSemi()
+	c.Body = p.parseBody(token.END, token.CASE)
+	return c
+}
+
+// ----------------------------------------------------------------------------
+// Declarations
+
+func (p *parser) parseFuncDecl() *ast.FuncDecl {
+	doc := p.leadComment
+	d := &ast.FuncDecl{Doc: doc, Function: p.expect(token.FUNCTION)}
+	if p.tok == token.WORD {
+		d.Name = &ast.Ident{NamePos: p.pos, Name: p.lit}
+		p.next()
+	} else {
+		p.errorExpected(p.pos, "a function name")
+		d.Name = &ast.Ident{NamePos: p.pos}
+	}
+
+	for p.tok == token.WORD {
+		if d.DashDash.IsValid() || !strings.HasPrefix(p.lit, "-") || p.lit == "-" {
+			d.Args = append(d.Args, p.parseWord())
+			continue
+		}
+		if p.lit == "--" {
+			d.DashDash = p.pos
+			p.next()
+			continue
+		}
+		p.parseFuncOpt(d)
+	}
+	p.expectSemi()
+
+	loopLev := p.loopLev
+	p.loopLev = 0
+	d.Body = p.parseBody(token.END)
+	p.loopLev = loopLev
+	d.EndPos = p.expectEnd(d.Function, "function definition")
+	return d
+}
+
+// funcOptions maps the short and long options of function to their
+// short letter, which selects the FuncDecl field they are kept in.
+var funcOptions = map[string]byte{
+	"-d": 'd', "--description": 'd',
+	"-a": 'a', "--argument-names": 'a',
+	"-w": 'w', "--wraps": 'w',
+	"-e": 'e', "--on-event": 'e',
+	"-v": 'v', "--on-variable": 'v',
+	"-s": 's', "--on-signal": 's',
+	"-j": 'j', "--on-job-exit": 'j',
+	"-p": 'p', "--on-process-exit": 'p',
+	"-V": 'V', "--inherit-variable": 'V',
+	"-S": 'S', "--no-scope-shadowing": 'S',
+}
+
+// parseFuncOpt parses an option of the function declaration d with
+// its value, and keeps it in d.
+func (p *parser) parseFuncOpt(d *ast.FuncDecl) {
+	o := &ast.FuncOpt{OptPos: p.pos, Opt: p.lit}
+	pos, lit := p.pos, p.lit
+	value := ""
+	if name, v, ok := strings.Cut(lit, "="); ok && strings.HasPrefix(lit, "--") {
+		o.Opt, value = name, v
+		o.Eq = pos + token.Pos(len(name))
+	}
+	c, ok := funcOptions[o.Opt]
+	if !ok {
+		p.error(pos, "function: "+o.Opt+": unknown option")
+		d.Args = append(d.Args, p.parseWord())
+		return
+	}
+	p.next()
+
+	switch {
+	case c == 'S':
+		if o.Eq.IsValid() {
+			p.error(pos, "function: "+o.Opt+": option does not take an argument")
+		}
+	case o.Eq.IsValid():
+		o.Value = p.wordParts(o.Eq+1, value)
+	case p.tok == token.WORD:
+		o.Value = p.parseWord()
+	default:
+		p.error(pos, "function: "+o.Opt+": option requires an argument")
+	}
+	if c == 'a' && o.Value != nil {
+		for p.tok == token.WORD && (!strings.HasPrefix(p.lit, "-") || p.lit == "-") {
+			o.Names = append(o.Names, p.parseWord())
+		}
+	}
+
+	switch c {
+	case 'd':
+		d.Description = o
+	case 'a':
+		d.ArgNames = append(d.ArgNames, o)
+	case 'w':
+		d.Wraps = append(d.Wraps, o)
+	case 'e':
+		d.OnEvent = append(d.OnEvent, o)
+	case 'v':
+		d.OnVariable = append(d.OnVariable, o)
+	case 's':
+		d.OnSignal = append(d.OnSignal, o)
+	case 'j':
+		d.OnJobExit = append(d.OnJobExit, o)
+	case 'p':
+		d.OnProcessExit = append(d.OnProcessExit, o)
+	case 'V':
+		d.InheritVariable = append(d.InheritVariable, o)
+	case 'S':
+		d.NoScopeShadowing = o
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Source files
+
+func (p *parser) parseFile() *ast.File {
+	f := &ast.File{
+		FileStart: token.Pos(p.file.Base()),
+		FileEnd:   token.Pos(p.file.Base() + p.file.Size()),
+	}
+	// A comment group opening the file documents the file, unless
+	// it documents the first statement.
+	if len(p.comments) > 0 && p.comments[0] != p.leadComment {
+		f.Doc = p.comments[0]
+	}
+	for {
+		switch p.tok {
+		case token.EOF:
+			f.Comments = p.comments
+			return f
+		case token.SEMI:
+			p.next()
+		case token.FUNCTION:
+			f.Decls = append(f.Decls, p.parseFuncDecl())
+			p.expectSemi()
+		default:
+			f.Stmts = append(f.Stmts, p.parseStmt())
+		}
+	}
+}
@@ -0,0 +1,1044 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package parser_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/parser"
+	"github.com/hulo-io/fishparser/scanner"
+	"github.com/hulo-io/fishparser/token"
+)
+
+const configFish = `set -gx PATH $HOME/bin $PATH
+
+function fish_greeting --description 'Say hi'
+    echo "Hello, $USER"
+end
+
+if test -d ~/.cargo/bin
+    fish_add_path ~/.cargo/bin
+else if type -q go
+    set -gx GOPATH (go env GOPATH)
+else
+    echo nothing >&2
+end
+
+for f in ~/.config/fish/conf.d/*.fish
+    source $f
+end
+
+while read -l line
+    switch $line
+        case 'a*' b
+            continue
+        case '*'
+            break
+    end
+end
+`
+
+// text returns the source text spanned by node n.
+func text(fset *token.FileSet, src string, n ast.Node) string {
+	file := fset.File(n.Pos())
+	return src[file.Offset(n.Pos()):file.Offset(n.End())]
+}
+
+func TestParseFile(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "config.fish", configFish, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Decls) != 1 || len(f.Stmts) != 4 {
+		t.Fatalf("got %d decls and %d stmts, want 1 and 4", len(f.Decls), len(f.Stmts))
+	}
+
+	fn := f.Decls[0].(*ast.FuncDecl)
+	if fn.Name.Name != "fish_greeting" || fn.Description == nil || len(fn.Body.List) != 1 {
+		t.Errorf("unexpected function %s with description %v", fn.Name.Name, fn.Description)
+	}
+	if got, want := text(fset, configFish, fn), "function fish_greeting --description 'Say hi'\n    echo \"Hello, $USER\"\nend"; got != want {
+		t.Errorf("function spans %q, want %q", got, want)
+	}
+
+	set := f.Stmts[0].(*ast.SetStmt)
+	if set.Scope != ast.ScopeGlobal || !set.Export || set.Vars[0].Name.Name != "PATH" || len(set.Values) != 2 {
+		t.Errorf("unexpected set statement %+v", set)
+	}
+	if got := text(fset, configFish, set); got != "set -gx PATH $HOME/bin $PATH" {
+		t.Errorf("set spans %q", got)
+	}
+
+	ifStmt := f.Stmts[1].(*ast.IfStmt)
+	if len(ifStmt.Elif) != 1 || ifStmt.Else == nil {
+		t.Fatalf("got %d else-if branches and else %v", len(ifStmt.Elif), ifStmt.Else)
+	}
+	if got := text(fset, configFish, ifStmt.Elif[0].Cond); got != "type -q go" {
+		t.Errorf("else-if condition spans %q", got)
+	}
+	if got, want := text(fset, configFish, ifStmt.Elif[0]), "else if type -q go\n    set -gx GOPATH (go env GOPATH)"; got != want {
+		t.Errorf("else-if branch spans %q, want %q", got, want)
+	}
+	if got, want := text(fset, configFish, ifStmt.Else), "else\n    echo nothing >&2"; got != want {
+		t.Errorf("else branch spans %q, want %q", got, want)
+	}
+	gopath := ifStmt.Elif[0].Body.List[0].(*ast.SetStmt)
+	subst := gopath.Values[0].(*ast.Word).Parts[0].(*ast.CmdSubst)
+	if got := text(fset, configFish, subst); got != "(go env GOPATH)" {
+		t.Errorf("command substitution spans %q", got)
+	}
+	if got := text(fset, configFish, subst.X); got != "go env GOPATH" {
+		t.Errorf("command substitution body spans %q", got)
+	}
+	redir := ifStmt.Else.Body.List[0].(*ast.ExprStmt).X.(*ast.CallExpr).Redirs[0]
+	if redir.Fd != 1 || redir.Op != ">&" || text(fset, configFish, redir.Word) != "2" {
+		t.Errorf("got redirection %d%s to %q", redir.Fd, redir.Op, text(fset, configFish, redir.Word))
+	}
+
+	loop := f.Stmts[2].(*ast.ForeachStmt)
+	if loop.Elem.(*ast.Ident).Name != "f" || len(loop.Group) != 1 {
+		t.Errorf("unexpected for loop over %v", loop.Group)
+	}
+
+	while := f.Stmts[3].(*ast.WhileStmt)
+	sw := while.Body.List[0].(*ast.SwitchStmt)
+	if len(sw.Cases) != 2 || len(sw.Cases[0].Patterns) != 2 {
+		t.Fatalf("unexpected switch with %d cases", len(sw.Cases))
+	}
+	if got := text(fset, configFish, sw.Cases[0].Patterns[0]); got != "'a*'" {
+		t.Errorf("case pattern spans %q", got)
+	}
+	if got, want := text(fset, configFish, while)[len(text(fset, configFish, while))-3:], "end"; got != want {
+		t.Errorf("while ends with %q", got)
+	}
+}
+
+func TestParseWord(t *testing.T) {
+	const src = `echo "$HOME/x"/bin'$x'(pwd)\ a`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "word.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := f.Stmts[0].(*ast.ExprStmt).X.(*ast.CallExpr).Recv[0].(*ast.Word)
+	if len(w.Parts) != 5 {
+		t.Fatalf("got %d parts, want 5", len(w.Parts))
+	}
+	if got := text(fset, src, w); got != src[len("echo "):] {
+		t.Errorf("word spans %q", got)
+	}
+
+	dq := w.Parts[0].(*ast.DoubleQuoted)
+	if len(dq.Parts) != 2 || text(fset, src, dq) != `"$HOME/x"` {
+		t.Fatalf("got double-quoted string %q of %d parts", text(fset, src, dq), len(dq.Parts))
+	}
+	if v := dq.Parts[0].(*ast.VarExpansion); v.X.(*ast.Ident).Name != "HOME" {
+		t.Errorf("got expansion of %v", v.X)
+	}
+	if lit := dq.Parts[1].(*ast.Lit); lit.Value != "/x" {
+		t.Errorf("got %q after the expansion", lit.Value)
+	}
+	if lit := w.Parts[1].(*ast.Lit); lit.Value != "/bin" {
+		t.Errorf("got %q", lit.Value)
+	}
+	if sq := w.Parts[2].(*ast.SingleQuoted); sq.Value != "$x" || text(fset, src, sq) != "'$x'" {
+		t.Errorf("got %q spanning %q", sq.Value, text(fset, src, sq))
+	}
+	if subst := w.Parts[3].(*ast.CmdSubst); subst.X.(*ast.CallExpr).Func.Name != "pwd" {
+		t.Errorf("got substitution of %v", subst.X)
+	}
+	if lit := w.Parts[4].(*ast.Lit); lit.Value != `\ a` {
+		t.Errorf("got %q, want the escape as written", lit.Value)
+	}
+
+	// only unquoted text holding a "*" is a Glob; a "?" is left to
+	// the qmark-noglob feature
+	x, err := parser.ParseExpr(`ls conf.d/*.fish a?* what? 'a*' a\* "*"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, arg := range x.(*ast.CallExpr).Recv {
+		_, glob := arg.(*ast.Word).Parts[0].(*ast.Glob)
+		if want := i < 2; glob != want {
+			t.Errorf("argument %s: got glob %v, want %v", ast.ExprStr(arg), glob, want)
+		}
+	}
+
+	// the quoting is printed as written
+	for _, src := range []string{
+		`echo "a \"b\" $c[1]"'d\'e' "$" "$(date)" \$x ''`,
+		`printf '%s\n' "$$name" {$a}b`,
+	} {
+		x, err := parser.ParseExpr(src)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		if got := ast.ExprStr(x); got != src {
+			t.Errorf("printed %q as %q", src, got)
+		}
+	}
+}
+
+func TestParseBraceExp(t *testing.T) {
+	tests := []struct {
+		src    string
+		want   string // printed word
+		braces int    // number of brace expansions
+	}{
+		{"src/{cmd,internal}", "src/{cmd,internal}", 1},
+		{"{a, b ,\\ }", "{a,b,\\ }", 1},
+		{"x{,.bak}", "x{,.bak}", 1},
+		{"{a,b{1,2}}{c,d}", "{a,b{1,2}}{c,d}", 3},
+		{"{{a,b}}", "{{a,b}}", 1},
+		{"{'a,b',$c}", "{'a,b',$c}", 1},
+		{"{a}", "{a}", 0},
+		{"{}", "{}", 0},
+		{"HEAD@{2}", "HEAD@{2}", 0},
+		{"{$x}s", "{$x}s", 0},
+	}
+	for _, tt := range tests {
+		x, err := parser.ParseExpr("echo " + tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		w := x.(*ast.CallExpr).Recv[0]
+		if got := ast.ExprStr(w); got != tt.want {
+			t.Errorf("printed %q as %q, want %q", tt.src, got, tt.want)
+		}
+		n := 0
+		ast.Inspect(w, func(node ast.Node) bool {
+			if _, ok := node.(*ast.BraceExp); ok {
+				n++
+			}
+			return true
+		})
+		if n != tt.braces {
+			t.Errorf("%q: got %d brace expansions, want %d", tt.src, n, tt.braces)
+		}
+	}
+
+	const src = "mkdir {a,b}"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "brace.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := f.Stmts[0].(*ast.ExprStmt).X.(*ast.CallExpr).Recv[0].(*ast.Word).Parts[0].(*ast.BraceExp)
+	if got := text(fset, src, b); got != "{a,b}" {
+		t.Errorf("brace expansion spans %q", got)
+	}
+	if got := text(fset, src, b.Elems[1]); got != "b" {
+		t.Errorf("second item spans %q", got)
+	}
+}
+
+func TestParseCmdSubst(t *testing.T) {
+	const src = `echo (ls)$(pwd) "at $(date | tr a b) (not this)"`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "subst.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []*ast.CmdSubst
+	ast.Inspect(f, func(n ast.Node) bool {
+		if x, ok := n.(*ast.CmdSubst); ok {
+			list = append(list, x)
+		}
+		return true
+	})
+	want := []struct {
+		text   string
+		dollar bool
+		quoted bool
+	}{
+		{"(ls)", false, false},
+		{"$(pwd)", true, false},
+		{"$(date | tr a b)", true, true},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("got %d substitutions, want %d", len(list), len(want))
+	}
+	for i, x := range list {
+		w := want[i]
+		if got := text(fset, src, x); got != w.text || x.Dollar.IsValid() != w.dollar || x.Quoted != w.quoted {
+			t.Errorf("got %q (dollar %v, quoted %v), want %q (dollar %v, quoted %v)",
+				got, x.Dollar.IsValid(), x.Quoted, w.text, w.dollar, w.quoted)
+		}
+	}
+	if got := ast.ExprStr(f.Stmts[0].(*ast.ExprStmt).X); got != src {
+		t.Errorf("printed as %q", got)
+	}
+}
+
+func TestParseVarExpansion(t *testing.T) {
+	tests := []struct {
+		src   string
+		deref int      // number of "$$" dereferences
+		name  string   // innermost variable name
+		index []string // spelling of each index list
+	}{
+		{"$PATH", 0, "PATH", nil},
+		{"$argv[1]", 0, "argv", []string{"[1]"}},
+		{"$argv[-1]", 0, "argv", []string{"[-1]"}},
+		{"$argv[2..-1]", 0, "argv", []string{"[2..-1]"}},
+		{"$list[1 3 5]", 0, "list", []string{"[1 3 5]"}},
+		{"$list[..2 $i..][1]", 0, "list", []string{"[..2 $i..]", "[1]"}},
+		{"$list[(count $list)]", 0, "list", []string{"[(count $list)]"}},
+		{"$$name", 1, "name", nil},
+		{"$$$name[2]", 2, "name", []string{"[2]"}},
+		{"{$var}", 0, "var", nil},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		src := "echo " + tt.src
+		f, err := parser.ParseFile(fset, "var.fish", src, 0)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		w := f.Stmts[0].(*ast.ExprStmt).X.(*ast.CallExpr).Recv[0].(*ast.Word)
+		x, ok := w.Parts[0].(*ast.VarExpansion)
+		if !ok || len(w.Parts) != 1 {
+			t.Errorf("%q: got %d parts, starting with %T", tt.src, len(w.Parts), w.Parts[0])
+			continue
+		}
+		if got := text(fset, src, x); got != tt.src {
+			t.Errorf("%q: expansion spans %q", tt.src, got)
+		}
+		if got := ast.ExprStr(x); got != tt.src {
+			t.Errorf("%q: printed as %q", tt.src, got)
+		}
+		deref := 0
+		for {
+			inner, ok := x.X.(*ast.VarExpansion)
+			if !ok {
+				break
+			}
+			if len(x.Index) != 0 {
+				t.Errorf("%q: outer expansion has an index", tt.src)
+			}
+			x = inner
+			deref++
+		}
+		if deref != tt.deref || x.X.(*ast.Ident).Name != tt.name || len(x.Index) != len(tt.index) {
+			t.Errorf("%q: got %d dereferences of %s with %d indices", tt.src, deref, ast.ExprStr(x.X), len(x.Index))
+			continue
+		}
+		for i, index := range x.Index {
+			if got := text(fset, src, index); got != tt.index[i] {
+				t.Errorf("%q: index %d spans %q", tt.src, i, got)
+			}
+		}
+	}
+
+	// a range may leave out either end
+	x, err := parser.ParseExpr("echo $a[2..]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := x.(*ast.CallExpr).Recv[0].(*ast.Word).Parts[0].(*ast.VarExpansion).Index[0].List[0].(*ast.RangeExpr)
+	if ast.ExprStr(r.Low) != "2" || r.High != nil {
+		t.Errorf("got range %s", ast.ExprStr(r))
+	}
+
+	// expansions are parts of a word
+	x, err = parser.ParseExpr("echo {$dir}_old/$name.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ast.ExprStr(x); got != "echo {$dir}_old/$name.txt" {
+		t.Errorf("printed as %q", got)
+	}
+}
+
+func TestParseJob(t *testing.T) {
+	const src = "not grep -q foo file 2>| wc -l && echo yes || echo no"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "job.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := f.Stmts[0].(*ast.ExprStmt).X.(*ast.JobConjunction)
+	if len(c.Jobs) != 3 || c.Ops[0] != token.AND || c.Ops[1] != token.OR || c.DecTok != token.NONE {
+		t.Fatalf("got %d jobs joined by %v", len(c.Jobs), c.Ops)
+	}
+	if got := text(fset, src, c); got != src {
+		t.Errorf("conjunction spans %q", got)
+	}
+	if got := src[c.OpPos[1]-1:][:2]; got != "||" {
+		t.Errorf("second operator at %q", got)
+	}
+	not := c.Jobs[0].(*ast.Job)
+	if not.NotTok != token.NOT_KW || not.NotPos != c.Pos() || not.Amp.IsValid() {
+		t.Fatalf("got job %+v, want negation", not)
+	}
+	pl := not.X.(*ast.Pipeline)
+	if len(pl.Cmds) != 2 || pl.Pipes[0].Op != token.GT_PIPE || pl.Pipes[0].Fd != 2 {
+		t.Fatalf("got pipeline of %d commands with %+v", len(pl.Cmds), pl.Pipes)
+	}
+	if got := text(fset, src, pl.Pipes[0]); got != "2>|" {
+		t.Errorf("pipe spans %q", got)
+	}
+
+	const bg = "sleep 10 &\nand make &| tee log & wait; or ! true\n"
+	fset = token.NewFileSet()
+	f, err = parser.ParseFile(fset, "job.fish", bg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Stmts) != 4 {
+		t.Fatalf("got %d statements, want 4", len(f.Stmts))
+	}
+	if j := f.Stmts[0].(*ast.ExprStmt).X.(*ast.Job); text(fset, bg, j) != "sleep 10 &" {
+		t.Errorf("background job spans %q", text(fset, bg, j))
+	}
+	and := f.Stmts[1].(*ast.ExprStmt).X.(*ast.JobConjunction)
+	if and.DecTok != token.AND_KW || len(and.Jobs) != 1 || text(fset, bg, and) != "and make &| tee log &" {
+		t.Errorf("got decorated job %q", text(fset, bg, and))
+	}
+	if pipe := and.Jobs[0].(*ast.Job).X.(*ast.Pipeline).Pipes[0]; pipe.Op != token.AND_PIPE || pipe.Fd != 1 {
+		t.Errorf("got pipe %+v", pipe)
+	}
+	if _, ok := f.Stmts[2].(*ast.ExprStmt).X.(*ast.CallExpr); !ok {
+		t.Errorf("got %T, want the wait command", f.Stmts[2].(*ast.ExprStmt).X)
+	}
+	or := f.Stmts[3].(*ast.ExprStmt).X.(*ast.JobConjunction)
+	if or.DecTok != token.OR_KW || or.Jobs[0].(*ast.Job).NotTok != token.BITNOT {
+		t.Errorf("got %q", text(fset, bg, or))
+	}
+}
+
+func TestParseStmtExpr(t *testing.T) {
+	tests := []struct {
+		src  string
+		stmt string // statement run by the job
+	}{
+		{"test -n x; and return 1", "*ast.ReturnStmt"},
+		{"contains $i 2; and break", "*ast.BreakStmt"},
+		{"cmd && return 0", "*ast.ReturnStmt"},
+		{"not begin; true; end", "*ast.BeginStmt"},
+		{"ls | while read -l f; echo $f; end", "*ast.WhileStmt"},
+		{"begin; echo; end | sort", "*ast.BeginStmt"},
+		{"true; or for x in a b; echo $x; end &", "*ast.ForeachStmt"},
+		{"! if true; echo; end", "*ast.IfStmt"},
+		{"echo x | switch (cat); case x; echo; end", "*ast.SwitchStmt"},
+	}
+	for _, tt := range tests {
+		src := "while true\n" + tt.src + "\nend\n"
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "job.fish", src, 0)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		body := f.Stmts[0].(*ast.WhileStmt).Body.List
+		s, ok := body[len(body)-1].(*ast.ExprStmt)
+		if !ok {
+			t.Errorf("%q: got %T, want a job", tt.src, body[len(body)-1])
+			continue
+		}
+		if got := text(fset, src, s); !strings.HasSuffix(tt.src, got) {
+			t.Errorf("job spans %q, want the end of %q", got, tt.src)
+		}
+		var stmts []string
+		ast.Inspect(s, func(n ast.Node) bool {
+			if x, ok := n.(*ast.StmtExpr); ok {
+				stmts = append(stmts, fmt.Sprintf("%T", x.Stmt))
+			}
+			return true
+		})
+		if len(stmts) != 1 || stmts[0] != tt.stmt {
+			t.Errorf("%q runs %v, want a %s", tt.src, stmts, tt.stmt)
+		}
+	}
+
+	// a block that is the whole job is a statement by itself
+	f, err := parser.ParseFile(token.NewFileSet(), "job.fish", "begin; echo; end >out\nreturn", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Stmts[0].(*ast.BeginStmt); !ok {
+		t.Errorf("got %T, want *ast.BeginStmt", f.Stmts[0])
+	}
+	if _, ok := f.Stmts[1].(*ast.ReturnStmt); !ok {
+		t.Errorf("got %T, want *ast.ReturnStmt", f.Stmts[1])
+	}
+
+	const src = `if not begin
+        true
+    end
+    ls | while read -l f
+        echo $f
+    end
+    begin
+        echo
+    end | sort
+    test -n x; and return 1
+end
+`
+	f, err = parser.ParseFile(token.NewFileSet(), "job.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(src, "; and", "\n    and", 1)
+	if got := ast.String(f); got != want {
+		t.Errorf("printed as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode parser.Mode
+		want []string // fd, operator and target of each redirection
+	}{
+		{"cmd <in >out 2>>log", 0, []string{"0 < in", "1 > out", "2 >> log"}},
+		{"cmd 2>&1 >&- &>all &>>all", 0, []string{"2 >& 1", "1 >& -", "1 &> all", "1 &>> all"}},
+		{"cmd >?new <?maybe 10<&3", 0, []string{"1 >? new", "0 <? maybe", "10 <& 3"}},
+		{"cmd ^err ^^log", parser.CaretRedirects, []string{"2 ^ err", "2 ^^ log"}},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "redirect.fish", tt.src, tt.mode)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		call := f.Stmts[0].(*ast.ExprStmt).X.(*ast.CallExpr)
+		if len(call.Recv) != 0 || len(call.Redirs) != len(tt.want) {
+			t.Errorf("%q: got %d arguments and %d redirections", tt.src, len(call.Recv), len(call.Redirs))
+			continue
+		}
+		for i, r := range call.Redirs {
+			if got := fmt.Sprintf("%d %s %s", r.Fd, r.Op, text(fset, tt.src, r.Word)); got != tt.want[i] {
+				t.Errorf("%q: redirection %d is %s, want %s", tt.src, i, got, tt.want[i])
+			}
+		}
+		if got := text(fset, tt.src, call); got != tt.src {
+			t.Errorf("%q: command spans %q", tt.src, got)
+		}
+	}
+
+	// redirections of a block follow its "end"
+	const src = "while read -l line\n    echo $line\nend <input 2>/dev/null\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "redirect.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := f.Stmts[0].(*ast.WhileStmt)
+	if len(loop.Redirs) != 2 || loop.Redirs[1].Fd != 2 {
+		t.Fatalf("got block redirections %v", loop.Redirs)
+	}
+	if got := text(fset, src, loop); got != src[:len(src)-1] {
+		t.Errorf("while loop spans %q", got)
+	}
+}
+
+func TestParseSet(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string // printed statement; empty if it remains a command
+	}{
+		{"set -x --global EDITOR vim", "set -gx EDITOR vim"},
+		{"set -l x", "set -l x"},
+		{"set --append --path PATH ~/bin ~/.local/bin", "set -a --path PATH ~/bin ~/.local/bin"},
+		{"set -U -u fish_greeting ''", "set -Uu fish_greeting ''"},
+		{"set list[2 3] a b", "set list[2 3] a b"},
+		{"set -e -f tmp list[1]", "set -fe tmp list[1]"},
+		{"set -q EDITOR VISUAL 2>/dev/null", "set -q EDITOR VISUAL 2>/dev/null"},
+		{"set -- x -l", "set x -l"},
+		{`set "-g" x 1`, "set -g x 1"},
+		{"set", ""},
+		{"set -q", ""},
+		{"set -n", ""},
+		{"set -l -g x 1", ""},
+		{"set -x -u x 1", ""},
+		{"set $name value", ""},
+	}
+	for _, tt := range tests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "set.fish", tt.src, 0)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		s, ok := f.Stmts[0].(*ast.SetStmt)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%q: got a SetStmt, want a command", tt.src)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%q: got %T, want a SetStmt", tt.src, f.Stmts[0])
+			continue
+		}
+		if got := ast.String(s); got != tt.want+"\n" {
+			t.Errorf("%q: printed as %q, want %q", tt.src, got, tt.want)
+		}
+		if got := text(fset, tt.src, s); got != tt.src {
+			t.Errorf("%q: statement spans %q", tt.src, got)
+		}
+	}
+
+	const src = "set -l list[1 -1] a"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "set.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := f.Stmts[0].(*ast.SetStmt).Vars[0]
+	if len(v.Index.List) != 2 || text(fset, src, v.Index.List[1]) != "-1" || text(fset, src, v) != "list[1 -1]" {
+		t.Errorf("got index %v of %q", v.Index.List, text(fset, src, v))
+	}
+}
+
+func TestParseBegin(t *testing.T) {
+	const src = "while true\n    begin\n        set -l x 1\n        break\n    end 2>&1\nend\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "begin.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := f.Stmts[0].(*ast.WhileStmt)
+	b, ok := loop.Body.List[0].(*ast.BeginStmt)
+	if !ok {
+		t.Fatalf("got %T, want *ast.BeginStmt", loop.Body.List[0])
+	}
+	if len(b.Body.List) != 2 || len(b.Redirs) != 1 || b.Redirs[0].Fd != 2 {
+		t.Errorf("got begin block of %d statements and %d redirections", len(b.Body.List), len(b.Redirs))
+	}
+	if got, want := text(fset, src, b), "begin\n        set -l x 1\n        break\n    end 2>&1"; got != want {
+		t.Errorf("begin block spans %q, want %q", got, want)
+	}
+
+	_, err = parser.ParseFile(token.NewFileSet(), "begin.fish", "begin; echo", 0)
+	if err == nil || err.Error() != "begin.fish:1:1: Missing end to balance this begin" {
+		t.Errorf("got error %v", err)
+	}
+}
+
+func TestParseCond(t *testing.T) {
+	const src = `if set -q foo; and test -n "$foo"
+    echo $foo
+else if test -d a
+    or test -d b
+    echo dir
+end
+while begin
+        read -l line
+        or test -n "$line"
+    end
+    echo $line
+end
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "cond.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifStmt := f.Stmts[0].(*ast.IfStmt)
+	cond, ok := ifStmt.Cond.(*ast.JobList)
+	if !ok || len(cond.List) != 2 || len(ifStmt.Body.List) != 1 {
+		t.Fatalf("got condition %T and %d statements", ifStmt.Cond, len(ifStmt.Body.List))
+	}
+	if got := text(fset, src, cond); got != `set -q foo; and test -n "$foo"` {
+		t.Errorf("condition spans %q", got)
+	}
+	and := cond.List[1].(*ast.ExprStmt).X.(*ast.JobConjunction)
+	if and.DecTok != token.AND_KW {
+		t.Errorf("got decoration %q, want and", and.DecTok)
+	}
+	elif := ifStmt.Elif[0]
+	if list, ok := elif.Cond.(*ast.JobList); !ok || len(list.List) != 2 || len(elif.Body.List) != 1 {
+		t.Errorf("got else-if condition %T and %d statements", elif.Cond, len(elif.Body.List))
+	}
+
+	while := f.Stmts[1].(*ast.WhileStmt)
+	list, ok := while.Cond.(*ast.JobList)
+	if !ok || len(list.List) != 1 || len(while.Body.List) != 1 {
+		t.Fatalf("got condition %T and %d statements", while.Cond, len(while.Body.List))
+	}
+	if b, ok := list.List[0].(*ast.BeginStmt); !ok || len(b.Body.List) != 2 {
+		t.Errorf("got condition %T, want a begin block of 2 jobs", list.List[0])
+	}
+
+	want := strings.Replace(src, "; and", "\n    and", 1)
+	if got := ast.String(f); got != want {
+		t.Errorf("printed as\n%s\nwant\n%s", got, want)
+	}
+	if got := ast.ExprStr(cond); got != `set -q foo; and test -n "$foo"` {
+		t.Errorf("ExprStr(condition) = %s", got)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	const src = `function check
+    if not status is-interactive
+        and status --current-filename >/dev/null
+        exit 2
+    end
+    status -n
+    status $sub
+    status bogus
+    return
+    cd $dir || return 1
+    return 0 >/dev/null
+    return 1 2
+end
+exit
+exit 1 2>/dev/null
+true
+or exit 1
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "status.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body.List
+	ifStmt := body[0].(*ast.IfStmt)
+	cond := ifStmt.Cond.(*ast.JobList)
+	inter := cond.List[0].(*ast.ExprStmt).X.(*ast.Job).X.(*ast.StatusCall)
+	if inter.Subcommand() != "is-interactive" || len(inter.Args) != 0 {
+		t.Errorf("got status %s with %d arguments", inter.Subcommand(), len(inter.Args))
+	}
+	file := cond.List[1].(*ast.ExprStmt).X.(*ast.JobConjunction).Jobs[0].(*ast.StatusCall)
+	if file.Subcommand() != "filename" || len(file.Redirs) != 1 {
+		t.Errorf("got status %s with %d redirections", file.Subcommand(), len(file.Redirs))
+	}
+	if got := text(fset, src, file); got != "status --current-filename >/dev/null" {
+		t.Errorf("status call spans %q", got)
+	}
+	exit := ifStmt.Body.List[0].(*ast.ExitStmt)
+	if code, ok := exit.Code(); !ok || code != 2 {
+		t.Errorf("got exit status %d, %v, want 2", code, ok)
+	}
+	if x := body[1].(*ast.ExprStmt).X.(*ast.StatusCall); x.Subcommand() != "line-number" {
+		t.Errorf("got status %s, want line-number", x.Subcommand())
+	}
+	for _, s := range body[2:4] {
+		if call, ok := s.(*ast.ExprStmt).X.(*ast.CallExpr); !ok || call.Func.Name != "status" {
+			t.Errorf("got %T, want a call of status", s.(*ast.ExprStmt).X)
+		}
+	}
+	ret := body[4].(*ast.ReturnStmt)
+	if _, ok := ret.Code(); ok || text(fset, src, ret) != "return" {
+		t.Errorf("return spans %q", text(fset, src, ret))
+	}
+	cd := body[5].(*ast.ExprStmt).X.(*ast.JobConjunction)
+	if ret, ok := cd.Jobs[1].(*ast.StmtExpr).Stmt.(*ast.ReturnStmt); !ok || text(fset, src, ret) != "return 1" {
+		t.Errorf("got %s, want return 1", ast.ExprStr(cd.Jobs[1]))
+	}
+	ret = body[6].(*ast.ReturnStmt)
+	if code, ok := ret.Code(); !ok || code != 0 || len(ret.Redirs) != 1 || text(fset, src, ret) != "return 0 >/dev/null" {
+		t.Errorf("return spans %q", text(fset, src, ret))
+	}
+	if call, ok := body[7].(*ast.ExprStmt).X.(*ast.CallExpr); !ok || call.Func.Name != "return" || len(call.Recv) != 2 {
+		t.Errorf("got %s, want a call of return with 2 arguments", ast.ExprStr(body[7].(*ast.ExprStmt).X))
+	}
+	exit = f.Stmts[0].(*ast.ExitStmt)
+	if _, ok := exit.Code(); ok || exit.X != nil || text(fset, src, exit) != "exit" {
+		t.Errorf("exit spans %q", text(fset, src, exit))
+	}
+	exit = f.Stmts[1].(*ast.ExitStmt)
+	if code, ok := exit.Code(); !ok || code != 1 || len(exit.Redirs) != 1 || text(fset, src, exit) != "exit 1 2>/dev/null" {
+		t.Errorf("exit spans %q", text(fset, src, exit))
+	}
+	or := f.Stmts[3].(*ast.ExprStmt).X.(*ast.JobConjunction)
+	if exit, ok := or.Jobs[0].(*ast.StmtExpr).Stmt.(*ast.ExitStmt); !ok || or.DecTok != token.OR_KW || text(fset, src, exit) != "exit 1" {
+		t.Errorf("got %s, want or exit 1", ast.ExprStr(or))
+	}
+
+	if got := ast.String(f); got != src {
+		t.Errorf("printed as\n%s\nwant\n%s", got, src)
+	}
+}
+
+func TestParseFuncDecl(t *testing.T) {
+	const src = `function on_pwd -d 'Show the directory' --on-variable PWD -a dir count --wraps=ls -S -- -x
+    echo $dir
+end`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "func.fish", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := f.Decls[0].(*ast.FuncDecl)
+	if got := text(fset, src, d.Description); got != "-d 'Show the directory'" {
+		t.Errorf("description spans %q", got)
+	}
+	if len(d.OnVariable) != 1 || text(fset, src, d.OnVariable[0].Value) != "PWD" {
+		t.Errorf("got %d --on-variable options", len(d.OnVariable))
+	}
+	if len(d.ArgNames) != 1 || ast.ExprStr(d.ArgNames[0].Value) != "dir" || len(d.ArgNames[0].Names) != 1 {
+		t.Errorf("got %d --argument-names options", len(d.ArgNames))
+	} else if got := text(fset, src, d.ArgNames[0]); got != "-a dir count" {
+		t.Errorf("argument names span %q", got)
+	}
+	w := d.Wraps[0]
+	if !w.Eq.IsValid() || w.Opt != "--wraps" || text(fset, src, w) != "--wraps=ls" || text(fset, src, w.Value) != "ls" {
+		t.Errorf("got option %s = %s spanning %q", w.Opt, ast.ExprStr(w.Value), text(fset, src, w))
+	}
+	if d.NoScopeShadowing == nil || d.NoScopeShadowing.Value != nil {
+		t.Errorf("got --no-scope-shadowing %+v", d.NoScopeShadowing)
+	}
+	if !d.DashDash.IsValid() || len(d.Args) != 1 || ast.ExprStr(d.Args[0]) != "-x" {
+		t.Errorf("got -- at %d and arguments %v", d.DashDash, d.Args)
+	}
+	if got := ast.String(d); got != src+"\n" {
+		t.Errorf("printed as %q, want %q", got, src+"\n")
+	}
+
+	// -a takes the names up to the next option; the words are printed
+	// in source order
+	for _, tt := range []struct {
+		src   string
+		names string // the argument names of -a
+		args  int
+	}{
+		{"function greet -a name greeting --description 'Say hi'\nend\n", "name greeting", 0},
+		{"function greet name -d hi -a greeting -- who\nend\n", "greeting", 2},
+	} {
+		src := tt.src
+		f, err := parser.ParseFile(token.NewFileSet(), "func.fish", src, 0)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		d := f.Decls[0].(*ast.FuncDecl)
+		var names []string
+		for _, o := range d.ArgNames {
+			names = append(names, ast.ExprStr(o.Value))
+			for _, x := range o.Names {
+				names = append(names, ast.ExprStr(x))
+			}
+		}
+		if got := strings.Join(names, " "); got != tt.names || len(d.Args) != tt.args {
+			t.Errorf("%q: got argument names %q and %d arguments, want %q and %d", src, got, len(d.Args), tt.names, tt.args)
+		}
+		if got := ast.String(d); got != src {
+			t.Errorf("printed as %q, want %q", got, src)
+		}
+	}
+
+	for _, opt := range []string{"-e fish_prompt", "--on-signal=INT", "-j caller", "-p %self", "-V x", "--inherit-variable y"} {
+		src := "function f " + opt + "; end"
+		f, err := parser.ParseFile(token.NewFileSet(), "func.fish", src, 0)
+		if err != nil {
+			t.Errorf("%q: %v", src, err)
+			continue
+		}
+		d := f.Decls[0].(*ast.FuncDecl)
+		if n := len(d.OnEvent) + len(d.OnSignal) + len(d.OnJobExit) + len(d.OnProcessExit) + len(d.InheritVariable); n != 1 || len(d.Args) != 0 {
+			t.Errorf("%q: got %d event and inherited options, %d arguments", src, n, len(d.Args))
+		}
+	}
+}
+
+func TestParseDeclStmt(t *testing.T) {
+	const src = `set -g x 1
+function a; end
+if not functions -q b
+    # b is a fallback.
+    function b
+        function c; end
+    end
+end
+function d; end
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "decl.fish", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Decls) != 2 || len(f.Stmts) != 2 {
+		t.Fatalf("got %d decls and %d stmts, want 2 and 2", len(f.Decls), len(f.Stmts))
+	}
+	ifStmt := f.Stmts[1].(*ast.IfStmt)
+	ds, ok := ifStmt.Body.List[0].(*ast.DeclStmt)
+	if !ok {
+		t.Fatalf("got %T, want *ast.DeclStmt", ifStmt.Body.List[0])
+	}
+	b := ds.Decl.(*ast.FuncDecl)
+	if b.Name.Name != "b" || b.Doc == nil || b.Doc.Text() != "b is a fallback.\n" {
+		t.Errorf("got function %s with doc %v", b.Name.Name, b.Doc)
+	}
+	if _, ok := b.Body.List[0].(*ast.DeclStmt); !ok {
+		t.Errorf("got %T in function body, want *ast.DeclStmt", b.Body.List[0])
+	}
+	if got, want := text(fset, src, ds), "function b\n        function c; end\n    end"; got != want {
+		t.Errorf("declaration spans %q, want %q", got, want)
+	}
+
+	var names []string
+	for _, n := range f.Nodes() {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			names = append(names, n.Name.Name)
+		case ast.Stmt:
+			names = append(names, fmt.Sprintf("%T", n))
+		}
+	}
+	if got, want := strings.Join(names, " "), "*ast.SetStmt a *ast.IfStmt d"; got != want {
+		t.Errorf("got nodes %s, want %s", got, want)
+	}
+	if got := ast.String(f); !strings.HasPrefix(got, "set -g x 1\nfunction a\n") {
+		t.Errorf("file printed as %q", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"if true\n  echo", "test.fish:1:1: Missing end to balance this if statement"},
+		{"end", "test.fish:1:1: 'end' outside of a block"},
+		{"echo (ls", "test.fish:1:6: unexpected end of string, parentheses do not match"},
+		{"for x; end", "test.fish:1:6: expected 'in', but found end of the statement"},
+		{"for x-y in a b; end", "test.fish:1:5: for: 'x-y' is not a valid variable name"},
+		{"echo (\n  if)", "test.fish:2:5: expected a command, but found end of the input"},
+		{"echo a | > f", "test.fish:1:10: expected a command, but found a redirection"},
+		{"switch; end", "test.fish:1:1: switch: expected exactly one argument, got 0"},
+		{"break", "test.fish:1:1: 'break' while not inside of loop"},
+		{"true; and break", "test.fish:1:11: 'break' while not inside of loop"},
+		{"echo a$", "test.fish:1:7: expected a variable name after this $"},
+		{"while true; echo (continue); end", "test.fish:1:19: 'continue' while not inside of loop"},
+		{"if true; case x; end", "test.fish:1:10: 'case' builtin not inside of switch block"},
+		{"function f --on-evnt x; end", "test.fish:1:12: function: --on-evnt: unknown option"},
+		{"function f -S=1; end", "test.fish:1:12: function: -S=1: unknown option"},
+		{"function f --wraps\nend", "test.fish:1:12: function: --wraps: option requires an argument"},
+	}
+	for _, tt := range tests {
+		_, err := parser.ParseFile(token.NewFileSet(), "test.fish", tt.src, 0)
+		list, ok := err.(scanner.ErrorList)
+		if !ok || len(list) == 0 || list[0].Error() != tt.msg {
+			t.Errorf("%q: got error %v, want %s", tt.src, err, tt.msg)
+		}
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	const src = `function a
+    if test -f x
+        echo yes |
+    end
+end
+
+while true
+    else
+    echo a | > f
+end
+
+switch a b
+    case x
+        break
+end
+
+function b
+    echo b
+`
+	f, err := parser.ParseFile(token.NewFileSet(), "broken.fish", src, parser.AllErrors)
+	want := []string{
+		"broken.fish:4:5: expected a command, but found keyword 'end'",
+		"broken.fish:8:5: 'else' builtin not inside of if block",
+		"broken.fish:9:14: expected a command, but found a redirection",
+		"broken.fish:12:1: switch: expected exactly one argument, got 2",
+		"broken.fish:14:9: 'break' while not inside of loop",
+		"broken.fish:17:1: Missing end to balance this function definition",
+	}
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		t.Fatalf("got %T, want scanner.ErrorList", err)
+	}
+	if len(list) != len(want) {
+		t.Errorf("got %d errors, want %d", len(list), len(want))
+	}
+	for i, e := range list {
+		if i >= len(want) || e.Error() != want[i] {
+			t.Errorf("error %d: got %s", i, e)
+		}
+	}
+	if len(f.Decls) != 2 {
+		t.Errorf("got %d functions, want 2", len(f.Decls))
+	}
+}
+
+const commentedFish = `#!/usr/bin/env fish
+# Install the user's tools.
+
+# greet says hello.
+# It takes no arguments.
+function greet
+    echo hello # say it
+end
+
+# Walk the arguments.
+for a in $argv
+    # each one
+    greet $a
+end
+
+# done
+`
+
+func TestParseComments(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "tools.fish", commentedFish, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Comments) != 6 {
+		t.Fatalf("got %d comment groups, want 6", len(f.Comments))
+	}
+	if got, want := f.Doc.Text(), "!/usr/bin/env fish\nInstall the user's tools.\n"; got != want {
+		t.Errorf("file doc is %q, want %q", got, want)
+	}
+
+	fn := f.Decls[0].(*ast.FuncDecl)
+	if got, want := fn.Doc.Text(), "greet says hello.\nIt takes no arguments.\n"; got != want {
+		t.Errorf("function doc is %q, want %q", got, want)
+	}
+	loop := f.Stmts[0].(*ast.ForeachStmt)
+	if got := loop.Doc.Text(); got != "Walk the arguments.\n" {
+		t.Errorf("for loop doc is %q", got)
+	}
+	if len(loop.Body.List) != 1 {
+		t.Errorf("for loop body has %d statements, want 1", len(loop.Body.List))
+	}
+
+	cmap := ast.NewCommentMap(fset, f, f.Comments)
+	echo := fn.Body.List[0]
+	if g := cmap[echo]; len(g) != 1 || g[0].Text() != "say it\n" {
+		t.Errorf("echo has comments %v, want the line comment", g)
+	}
+	greet := loop.Body.List[0]
+	if g := cmap[greet]; len(g) != 1 || g[0].Text() != "each one\n" {
+		t.Errorf("greet has comments %v, want its lead comment", g)
+	}
+	if g := cmap[loop]; len(g) != 1 || g[0] != loop.Doc {
+		t.Errorf("for loop has comments %v, want its doc", g)
+	}
+
+	// comments follow their node through a rewrite
+	repl := &ast.ExprStmt{X: &ast.CallExpr{Func: &ast.Ident{Name: "printf"}}}
+	fn.Body.List[0] = cmap.Update(echo, repl).(ast.Stmt)
+	if len(cmap.Filter(f)[repl]) != 1 || len(cmap.Comments()) != 6 {
+		t.Error("comment of echo was lost by Update")
+	}
+
+	// without ParseComments, no comments are collected
+	f, err = parser.ParseFile(token.NewFileSet(), "tools.fish", commentedFish, 0)
+	if err != nil || f.Comments != nil || f.Decls[0].(*ast.FuncDecl).Doc != nil {
+		t.Errorf("comments collected without ParseComments (err = %v)", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package printer implements printing of AST nodes as fish source,
+// laid out the way fish_indent lays it out by default.
+package printer
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/token"
+)
+
+// A Mode value is a set of flags (or 0). They control printing.
+type Mode uint
+
+const (
+	NoTrailingNewline Mode = 1 << iota // do not end the output with a newline
+	DropComments                       // do not print comments
+	DropBlankLines                     // do not keep the blank lines between statements
+)
+
+// A Config node controls the output of Fprint.
+type Config struct {
+	Mode     Mode // default: 0
+	Indent   int  // width of an indentation level in columns; 4 if zero
+	UseTabs  bool // indent with a tab per level instead of spaces
+	MaxWidth int  // break longer commands with "\" continuations; no limit if zero
+}
+
+// Fprint "pretty-prints" an AST node to output for a given configuration
+// cfg. Position information is interpreted relative to the file set fset,
+// which may be nil for a tree without positions. The node type must be
+// *ast.File, ast.Decl, ast.Stmt or ast.Expr.
+//
+// With a file set, the blank lines of the source are kept, and the
+// comments of a parsed *ast.File are printed where they appeared.
+// Without one, only the doc comments of the nodes are printed.
+//
+// Nodes that have no fish spelling are left out, and the first of
+// them is reported as an *ast.UnprintableError after the rest of
+// the tree has been printed.
+func (cfg *Config) Fprint(output io.Writer, fset *token.FileSet, node ast.Node) error {
+	// the layout is that of ast.Fprint, which prints
+	// with the default configuration and no file set
+	var buf bytes.Buffer
+	err := (&ast.PrintConfig{
+		Indent:         cfg.Indent,
+		UseTabs:        cfg.UseTabs,
+		MaxWidth:       cfg.MaxWidth,
+		DropComments:   cfg.Mode&DropComments != 0,
+		DropBlankLines: cfg.Mode&DropBlankLines != 0,
+	}).Fprint(&buf, fset, node)
+
+	out := buf.Bytes()
+	if cfg.Mode&NoTrailingNewline != 0 {
+		out = bytes.TrimSuffix(out, []byte("\n"))
+	}
+	if _, err := output.Write(out); err != nil {
+		return err
+	}
+	return err
+}
+
+// Fprint "pretty-prints" an AST node to output the way fish_indent does.
+// It calls Config.Fprint with default settings.
+func Fprint(output io.Writer, fset *token.FileSet, node ast.Node) error {
+	return (&Config{}).Fprint(output, fset, node)
+}
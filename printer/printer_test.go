@@ -0,0 +1,247 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package printer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hulo-io/fishparser/ast"
+	"github.com/hulo-io/fishparser/parser"
+	"github.com/hulo-io/fishparser/printer"
+	"github.com/hulo-io/fishparser/token"
+)
+
+const src = `# Prompt setup.
+set -gx EDITOR vim
+
+
+# greet says hello.
+function greet --argument-names who
+  if test -z "$who"   # nobody given
+    set who world
+  else if test $who = me
+      set who you
+  # keep any other name
+  else
+  set who (string trim $who)
+  end
+  echo Hello,   $who >&2
+  or return 1   2
+  return   0 >/dev/null
+end
+
+begin;   set -l tmp (mktemp)
+      greet $tmp
+end >/dev/null
+
+if not functions -q ll
+   # ll lists in long format.
+   function ll; ls -l $argv; end
+end
+
+while begin; read -l line
+  or test -n "$line"; end
+and not string match -q '#*' $line
+echo $line
+end
+
+switch $TERM
+case 'xterm*'
+    greet xterm
+case '*'
+    greet
+end   2>/dev/null # quiet
+
+string split : $PATH | # one per line
+sort -u  && # then
+  echo done
+
+history | while read -l cmd # each command
+echo $cmd; end | sort # sorted
+and return
+# trailing
+`
+
+const want = `# Prompt setup.
+set -gx EDITOR vim
+
+# greet says hello.
+function greet --argument-names who
+    if test -z "$who" # nobody given
+        set who world
+    else if test $who = me
+        set who you
+    # keep any other name
+    else
+        set who (string trim $who)
+    end
+    echo Hello, $who >&2
+    or return 1 2
+    return 0 >/dev/null
+end
+
+begin
+    set -l tmp (mktemp)
+    greet $tmp
+end >/dev/null
+
+if not functions -q ll
+    # ll lists in long format.
+    function ll
+        ls -l $argv
+    end
+end
+
+while begin
+        read -l line
+        or test -n "$line"
+    end
+    and not string match -q '#*' $line
+    echo $line
+end
+
+switch $TERM
+    case 'xterm*'
+        greet xterm
+    case '*'
+        greet
+end 2>/dev/null # quiet
+
+string split : $PATH | # one per line
+    sort -u && # then
+    echo done
+
+history | while read -l cmd # each command
+    echo $cmd
+end | sort # sorted
+and return
+# trailing
+`
+
+func parse(t *testing.T, src string) (*token.FileSet, *ast.File) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "prompt.fish", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fset, f
+}
+
+func TestFprint(t *testing.T) {
+	fset, f := parse(t, src)
+
+	var buf strings.Builder
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// printing the output again does not change it
+	fset, f = parse(t, want)
+	buf.Reset()
+	printer.Fprint(&buf, fset, f)
+	if got := buf.String(); got != want {
+		t.Errorf("output is not stable:\n%s", got)
+	}
+
+	// without a file set, the output is that of ast.Fprint
+	buf.Reset()
+	printer.Fprint(&buf, nil, f)
+	if got, want := buf.String(), ast.String(f); got != want {
+		t.Errorf("got\n%s\nwant the output of ast.String\n%s", got, want)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	const src = "function add_paths\nfish_add_path ~/bin ~/.local/bin ~/.cargo/bin ~/go/bin >/dev/null\nend\n"
+	fset, f := parse(t, src)
+
+	tests := []struct {
+		cfg  printer.Config
+		want string
+	}{
+		{printer.Config{}, "function add_paths\n    fish_add_path ~/bin ~/.local/bin ~/.cargo/bin ~/go/bin >/dev/null\nend\n"},
+		{printer.Config{Indent: 2}, "function add_paths\n  fish_add_path ~/bin ~/.local/bin ~/.cargo/bin ~/go/bin >/dev/null\nend\n"},
+		{printer.Config{UseTabs: true, Mode: printer.NoTrailingNewline}, "function add_paths\n\tfish_add_path ~/bin ~/.local/bin ~/.cargo/bin ~/go/bin >/dev/null\nend"},
+		{printer.Config{MaxWidth: 40}, "function add_paths\n" +
+			"    fish_add_path ~/bin ~/.local/bin \\\n" +
+			"        ~/.cargo/bin ~/go/bin >/dev/null\n" +
+			"end\n"},
+	}
+	for _, tt := range tests {
+		var buf strings.Builder
+		if err := tt.cfg.Fprint(&buf, fset, f); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%+v: got\n%s\nwant\n%s", tt.cfg, got, tt.want)
+		}
+	}
+
+	// a wrapped command parses back to the same command
+	var buf strings.Builder
+	(&printer.Config{MaxWidth: 40}).Fprint(&buf, fset, f)
+	_, g := parse(t, buf.String())
+	call := g.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.ExprStmt).X.(*ast.CallExpr)
+	if len(call.Recv) != 4 || len(call.Redirs) != 1 {
+		t.Errorf("wrapped command has %d arguments, want 4", len(call.Recv))
+	}
+}
+
+func TestFprintRedirect(t *testing.T) {
+	const src = "echo > ?foo\necho 2> ?x\necho &> ?y\necho >? foo\n"
+	const want = "echo > ?foo\necho 2> ?x\necho &> ?y\necho >?foo\n"
+	fset, f := parse(t, src)
+	var buf strings.Builder
+	if err := printer.Fprint(&buf, fset, f); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// the targets are not taken for part of the operators
+	_, g := parse(t, buf.String())
+	for i, s := range g.Stmts {
+		r := s.(*ast.ExprStmt).X.(*ast.CallExpr).Redirs[0]
+		orig := f.Stmts[i].(*ast.ExprStmt).X.(*ast.CallExpr).Redirs[0]
+		if r.Op != orig.Op || r.Fd != orig.Fd || ast.ExprStr(r.Word) != ast.ExprStr(orig.Word) {
+			t.Errorf("%s reparsed as %s", ast.ExprStr(orig), ast.ExprStr(r))
+		}
+	}
+}
+
+func TestFprintUnprintable(t *testing.T) {
+	stmt := &ast.ExprStmt{X: &ast.CallExpr{
+		Func: &ast.Ident{Name: "diff"},
+		Recv: []ast.Expr{&ast.ProcSubst{Tok: token.GT, X: &ast.CallExpr{Func: &ast.Ident{Name: "cat"}}}},
+	}}
+	var buf strings.Builder
+	err := printer.Fprint(&buf, nil, stmt)
+	if _, ok := err.(*ast.UnprintableError); !ok {
+		t.Errorf("got error %v, want an UnprintableError", err)
+	}
+	if got := buf.String(); got != "diff\n" {
+		t.Errorf("got %q, want the rest of the command", got)
+	}
+
+	// statements missing a required part are reported, not panicked on
+	for _, s := range []ast.Stmt{
+		&ast.SwitchStmt{Cases: []*ast.CaseClause{{Patterns: []ast.Expr{&ast.Ident{Name: "a"}}}}},
+		&ast.IfStmt{Body: &ast.BlockStmt{}},
+		&ast.WhileStmt{Cond: &ast.JobList{}},
+		&ast.IfStmt{Cond: &ast.Ident{Name: "true"}, Elif: []*ast.ElseIfClause{{}}},
+	} {
+		buf.Reset()
+		if err := printer.Fprint(&buf, nil, s); err == nil {
+			t.Errorf("%T printed as %q without an error", s, buf.String())
+		} else if _, ok := err.(*ast.UnprintableError); !ok {
+			t.Errorf("%T: got error %v, want an UnprintableError", s, err)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package quote implements conversions to and from the quoted
+// representations of strings in fish source, in the manner of
+// strconv.Quote and strconv.Unquote for Go.
+package quote
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrSyntax indicates that a word or escape sequence is malformed,
+// such as an unterminated quote or a "\u" without hex digits.
+var ErrSyntax = errors.New("invalid syntax")
+
+// ErrNotLiteral indicates that a word does not denote a single fixed
+// string: it expands a variable, substitutes a command, expands braces,
+// wildcards or "~", or is more than one word.
+var ErrNotLiteral = errors.New("word is not a literal")
+
+// A Context is the quoting context of text in a fish word.
+type Context int
+
+const (
+	Unquoted     Context = iota // outside of quotes
+	SingleQuoted                // between '...'
+	DoubleQuoted                // between "..."
+)
+
+// Unescape returns the string denoted by the text s appearing in the
+// context ctx, such as the Value of an ast.Lit or ast.SingleQuoted.
+// The text must not contain the quote closing its context; expansions
+// in it are taken literally.
+//
+// Between single quotes, only "\'" and "\\" are escapes. Between
+// double quotes, "\"", "\$", "\\" and a backslash-newline are. Outside
+// of quotes, fish recognizes
+//
+//	\a \b \e \f \n \r \t \v    control characters
+//	\xHH \XHH                  a byte given by one or two hex digits
+//	\ooo                       a byte given by one to three octal digits
+//	\uXXXX \UXXXXXXXX          a code point given by up to 4 or 8 hex digits
+//	\cX                        the control character of X, as in \cA
+//
+// and a backslash-newline is removed. Before any other character, the
+// backslash is dropped, which escapes characters such as "$", "*" and
+// the blank.
+func Unescape(s string, ctx Context) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 == len(s) {
+			if ctx == Unquoted {
+				return "", ErrSyntax // escapes the end of the word
+			}
+			b.WriteByte(c)
+			break
+		}
+		next := s[i+1]
+		switch ctx {
+		case SingleQuoted:
+			if next == '\'' || next == '\\' {
+				b.WriteByte(next)
+				i++
+				continue
+			}
+			b.WriteByte(c)
+			continue
+		case DoubleQuoted:
+			switch next {
+			case '"', '$', '\\':
+				b.WriteByte(next)
+				i++
+			case '\n':
+				i++
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		}
+
+		n, err := unescape(&b, s[i+1:])
+		if err != nil {
+			return "", err
+		}
+		i += n
+	}
+	return b.String(), nil
+}
+
+// unescape writes the character denoted by the unquoted escape
+// sequence at the start of s, which follows a backslash, and returns
+// the length of the sequence.
+func unescape(b *strings.Builder, s string) (int, error) {
+	switch c := s[0]; c {
+	case 'a':
+		b.WriteByte('\a')
+	case 'b':
+		b.WriteByte('\b')
+	case 'e':
+		b.WriteByte(0x1b)
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'v':
+		b.WriteByte('\v')
+	case '\n':
+		// a line continuation
+	case 'x', 'X':
+		v, n := digits(s[1:], 16, 2)
+		if n == 0 {
+			return 0, ErrSyntax
+		}
+		b.WriteByte(byte(v))
+		return 1 + n, nil
+	case 'u', 'U':
+		max := 4
+		if c == 'U' {
+			max = 8
+		}
+		v, n := digits(s[1:], 16, max)
+		if n == 0 || v > unicode.MaxRune || 0xD800 <= v && v < 0xE000 {
+			return 0, ErrSyntax
+		}
+		b.WriteRune(rune(v))
+		return 1 + n, nil
+	case 'c':
+		if len(s) < 2 || s[1] < '@' || s[1] > '~' {
+			return 0, ErrSyntax
+		}
+		b.WriteByte(s[1] & 0x1f)
+		return 2, nil
+	default:
+		if '0' <= c && c <= '7' {
+			v, n := digits(s, 8, 3)
+			if v > 0xff {
+				return 0, ErrSyntax
+			}
+			b.WriteByte(byte(v))
+			return n, nil
+		}
+		b.WriteByte(c)
+	}
+	return 1, nil
+}
+
+// digits parses up to max digits of the given base at the start of s,
+// and returns their value and number.
+func digits(s string, base, max int) (v, n int) {
+	for n < max && n < len(s) {
+		d := digitVal(s[n])
+		if d >= base {
+			break
+		}
+		v = v*base + d
+		n++
+	}
+	return
+}
+
+func digitVal(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return 16 // larger than any base
+}
+
+// Unquote interprets s as a single fish word, such as `it\'s`,
+// `"a b"` or `tab\tstop`, and returns the string it denotes. Quoted and
+// unquoted text may follow each other within the word.
+//
+// Unquote returns ErrSyntax if a quote is not terminated or an escape
+// sequence is malformed, and ErrNotLiteral if the word expands to
+// something else than itself or is not a single word.
+func Unquote(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '\'' || c == '"':
+			j := closingQuote(s, i)
+			if j < 0 {
+				return "", ErrSyntax
+			}
+			ctx := SingleQuoted
+			if c == '"' {
+				ctx = DoubleQuoted
+				if expandsQuoted(s[i+1 : j]) {
+					return "", ErrNotLiteral
+				}
+			}
+			t, err := Unescape(s[i+1:j], ctx)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(t)
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && s[j] != '\'' && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				} else if special(s[j], j == 0) {
+					return "", ErrNotLiteral
+				}
+				j++
+			}
+			if j > len(s) {
+				j = len(s) // a trailing backslash, reported by Unescape
+			}
+			t, err := Unescape(s[i:j], Unquoted)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(t)
+			i = j
+		}
+	}
+	return b.String(), nil
+}
+
+// closingQuote returns the index of the quote that closes the quoted
+// string starting at s[i], or -1 if there is none.
+func closingQuote(s string, i int) int {
+	q := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case q:
+			return j
+		}
+	}
+	return -1
+}
+
+// expandsQuoted reports whether the text s between double quotes
+// contains an unescaped "$".
+func expandsQuoted(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '$':
+			return true
+		}
+	}
+	return false
+}
+
+// special reports whether the unquoted character c expands or ends a
+// word. The characters "~" and "#" are only special at its start.
+func special(c byte, start bool) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '$', '*', '(', ')', '{', '}', '[', ']',
+		'<', '>', '&', '|', ';':
+		return true
+	case '~', '#':
+		return start
+	}
+	return false
+}
+
+// Quote returns a fish word that denotes s. Of the spellings of s
+// without quotes, between single quotes and between double quotes,
+// it returns the shortest, preferring them in the order
+// single-quoted, double-quoted and unquoted when they are as short.
+// A string that needs no quoting is returned as is, and the empty
+// string as a pair of single quotes.
+//
+// Control characters, invalid UTF-8 and unprintable characters can
+// only be spelled with escape sequences outside of quotes; a string
+// containing them is always returned unquoted.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	escaped, printable := Escape(s)
+	if escaped == s || !printable {
+		return escaped
+	}
+	best := escaped
+	for _, q := range []string{Double(s), Single(s)} {
+		if len(q) <= len(best) {
+			best = q
+		}
+	}
+	return best
+}
+
+// Single returns s between single quotes. Only a quote, and a
+// backslash that would otherwise escape one or another backslash,
+// are escaped.
+func Single(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\'':
+			b.WriteString(`\'`)
+		case c == '\\' && (i+1 == len(s) || s[i+1] == '\\' || s[i+1] == '\''):
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// Double returns s between double quotes, with "\"", "$" and the
+// backslashes that would otherwise start an escape sequence escaped.
+func Double(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '$':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\\' && (i+1 == len(s) || strings.IndexByte("\"$\\\n", s[i+1]) >= 0):
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Escape returns s without quotes, with every character that would
+// otherwise be special escaped with a backslash, and control and
+// unprintable characters spelled as escape sequences. It reports
+// whether s is made only of printable characters.
+func Escape(s string) (escaped string, printable bool) {
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	printable = true
+	for i := 0; i < len(s); {
+		r, w := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && w == 1:
+			b.WriteString(`\x`)
+			b.WriteByte(hex[s[i]>>4])
+			b.WriteByte(hex[s[i]&0xf])
+			printable = false
+		case r < 0x80 && (s[i] == '\\' || s[i] == '\'' || s[i] == '"' || s[i] == '?' || special(s[i], i == 0)):
+			if r == '\n' || r == '\t' || r == '\r' {
+				b.WriteString(controlEscapes[r])
+				printable = false
+				break
+			}
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		case r < 0x80 && controlEscapes[r] != "":
+			b.WriteString(controlEscapes[r])
+			printable = false
+		case r < 0x20 || r == 0x7f:
+			b.WriteString(`\x`)
+			b.WriteByte(hex[r>>4])
+			b.WriteByte(hex[r&0xf])
+			printable = false
+		case !unicode.IsPrint(r):
+			if r <= 0xffff {
+				b.WriteString(`\u`)
+				for shift := 12; shift >= 0; shift -= 4 {
+					b.WriteByte(hex[r>>shift&0xf])
+				}
+			} else {
+				b.WriteString(`\U`)
+				for shift := 28; shift >= 0; shift -= 4 {
+					b.WriteByte(hex[r>>shift&0xf])
+				}
+			}
+			printable = false
+		default:
+			b.WriteString(s[i : i+w])
+		}
+		i += w
+	}
+	return b.String(), printable
+}
+
+// controlEscapes holds the escape sequences of the control characters
+// that have a letter of their own.
+var controlEscapes = map[rune]string{
+	'\a': `\a`, '\b': `\b`, 0x1b: `\e`, '\f': `\f`,
+	'\n': `\n`, '\r': `\r`, '\t': `\t`, '\v': `\v`,
+}
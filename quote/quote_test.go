@@ -0,0 +1,125 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package quote_test
+
+import (
+	"testing"
+
+	"github.com/hulo-io/fishparser/quote"
+)
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		s    string
+		ctx  quote.Context
+		want string
+	}{
+		{`plain`, quote.Unquoted, "plain"},
+		{`a\ b\$c\*`, quote.Unquoted, "a b$c*"},
+		{`\a\b\e\f\n\r\t\v`, quote.Unquoted, "\a\b\x1b\f\n\r\t\v"},
+		{`\x41\X4a\x7`, quote.Unquoted, "AJ\x07"},
+		{`\xff`, quote.Unquoted, "\xff"},
+		{`\101\0`, quote.Unquoted, "A\x00"},
+		{`\u00e9\U0001F600\u41`, quote.Unquoted, "é😀A"},
+		{`\cA\c[`, quote.Unquoted, "\x01\x1b"},
+		{"a\\\nb", quote.Unquoted, "ab"},
+		{`\q`, quote.Unquoted, "q"},
+		{`it\'s \\ \n`, quote.SingleQuoted, `it's \ \n`},
+		{`\"$\$ \\ \n`, quote.DoubleQuoted, `"$$ \ \n`},
+		{"a\\\nb", quote.DoubleQuoted, "ab"},
+		{`end\`, quote.SingleQuoted, `end\`},
+	}
+	for _, tt := range tests {
+		got, err := quote.Unescape(tt.s, tt.ctx)
+		if err != nil {
+			t.Errorf("Unescape(%q, %d): %v", tt.s, tt.ctx, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unescape(%q, %d) = %q, want %q", tt.s, tt.ctx, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{`\`, `\x`, `\u`, `\UFFFFFFFF`, `\ud800`, `\400`, `\c`, `\c1`} {
+		if _, err := quote.Unescape(s, quote.Unquoted); err != quote.ErrSyntax {
+			t.Errorf("Unescape(%q): got error %v, want %v", s, err, quote.ErrSyntax)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		err  error
+	}{
+		{`word`, "word", nil},
+		{`''`, "", nil},
+		{`'a b'"c d"e\ f`, "a bc de f", nil},
+		{`"it's"`, "it's", nil},
+		{`'don'\''t'`, "don't", nil},
+		{`"\$HOME"`, "$HOME", nil},
+		{`a~b#c`, "a~b#c", nil},
+		{`\~`, "~", nil},
+		{`x?`, "x?", nil},
+		{`'a`, "", quote.ErrSyntax},
+		{`"a\"`, "", quote.ErrSyntax},
+		{`a\`, "", quote.ErrSyntax},
+		{`$HOME`, "", quote.ErrNotLiteral},
+		{`"$HOME"`, "", quote.ErrNotLiteral},
+		{`(pwd)`, "", quote.ErrNotLiteral},
+		{`*.go`, "", quote.ErrNotLiteral},
+		{`{a,b}`, "", quote.ErrNotLiteral},
+		{`~/bin`, "", quote.ErrNotLiteral},
+		{`a b`, "", quote.ErrNotLiteral},
+		{`a;b`, "", quote.ErrNotLiteral},
+	}
+	for _, tt := range tests {
+		got, err := quote.Unquote(tt.s)
+		if err != tt.err {
+			t.Errorf("Unquote(%q): got error %v, want %v", tt.s, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unquote(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", "''"},
+		{"plain", "plain"},
+		{"a~b", "a~b"},
+		{"~", `\~`},
+		{"a b", `a\ b`},
+		{"a b c d", "'a b c d'"},
+		{"it's", `it\'s`},
+		{"it's a b", `"it's a b"`},
+		{"$HOME and more", `'$HOME and more'`},
+		{`C:\dir`, `C:\\dir`},
+		{`a\b c`, `'a\b c'`},
+		{`end\ x`, `'end\ x'`},
+		{"*.go", `\*.go`},
+		{`"quoted" 'both'`, `'"quoted" \'both\''`},
+		{"tab\tstop", `tab\tstop`},
+		{"a b\n", `a\ b\n`},
+		{"\x1b[0m", `\e\[0m`},
+		{"\x00\x7f\xff", `\x00\x7f\xff`},
+		{"zero\u200bwidth", `zero\u200bwidth`},
+		{"héllo wörld", `héllo\ wörld`},
+	}
+	for _, tt := range tests {
+		got := quote.Quote(tt.s)
+		if got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+		back, err := quote.Unquote(got)
+		if err != nil || back != tt.s {
+			t.Errorf("Unquote(Quote(%q)) = %q, %v", tt.s, back, err)
+		}
+	}
+}
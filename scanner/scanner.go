@@ -0,0 +1,511 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package scanner implements a scanner for fish source text.
+// It takes a []byte as source which can then be tokenized
+// through repeated calls to the Scan method.
+//
+// Like fish's own tokenizer, the scanner works on whole words: quotes,
+// escapes, variables, brace expansions and command substitutions are
+// kept in the literal of the WORD token that contains them.
+package scanner
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/hulo-io/fishparser/token"
+)
+
+// An ErrorHandler may be provided to Scanner.Init. If a syntax error is
+// encountered and a handler was installed, the handler is called with a
+// position and an error message.
+type ErrorHandler func(pos token.Position, msg string)
+
+// A Mode value is a set of flags (or 0).
+// They control scanner behavior.
+type Mode uint
+
+const (
+	ScanComments   Mode = 1 << iota // return comments as COMMENT tokens
+	CaretRedirects                  // scan a leading "^" or "^^" as a stderr redirection, as fish 2 did
+)
+
+// A Scanner holds the scanner's internal state while processing
+// a given text. It can be allocated as part of another data
+// structure but must be initialized via Init before use.
+type Scanner struct {
+	// immutable state
+	file *token.File  // source file handle
+	src  []byte       // source
+	err  ErrorHandler // error reporting; or nil
+	mode Mode         // scanning mode
+
+	// scanning state
+	ch       rune // current character
+	offset   int  // character offset
+	rdOffset int  // reading offset (position after current character)
+	cmdPos   bool // whether the next word is in command position
+
+	// public state - ok to modify
+	ErrorCount int // number of errors encountered
+}
+
+const (
+	bom = 0xFEFF // byte order mark, only permitted as very first character
+	eof = -1     // end of file
+)
+
+// next reads the next Unicode char into s.ch.
+// s.ch < 0 means end-of-file.
+func (s *Scanner) next() {
+	if s.rdOffset < len(s.src) {
+		s.offset = s.rdOffset
+		if s.ch == '\n' {
+			s.file.AddLine(s.offset)
+		}
+		r, w := rune(s.src[s.rdOffset]), 1
+		if r >= utf8.RuneSelf {
+			r, w = utf8.DecodeRune(s.src[s.rdOffset:])
+		}
+		s.rdOffset += w
+		s.ch = r
+	} else {
+		s.offset = len(s.src)
+		s.ch = eof
+	}
+}
+
+// peek returns the byte following the most recently read character without
+// advancing the scanner. If the scanner is at EOF, peek returns 0.
+func (s *Scanner) peek() byte {
+	if s.rdOffset < len(s.src) {
+		return s.src[s.rdOffset]
+	}
+	return 0
+}
+
+// Init prepares the scanner s to tokenize the text src by setting the
+// scanner at the beginning of src. The scanner uses the file set file
+// for position information. Init panics if the file size does not
+// match the src size.
+//
+// Calls to Scan will invoke the error handler err if they encounter a
+// syntax error and err is not nil. Also, for each error encountered,
+// the Scanner field ErrorCount is incremented by one.
+func (s *Scanner) Init(file *token.File, src []byte, err ErrorHandler, mode Mode) {
+	if file.Size() != len(src) {
+		panic(fmt.Sprintf("file size (%d) does not match src len (%d)", file.Size(), len(src)))
+	}
+	s.InitRange(file, src, 0, len(src), err, mode)
+}
+
+// InitRange is like Init but only tokenizes src[start:end], as if the
+// rest of src did not exist. Positions remain relative to file. It is
+// used to scan the body of a command substitution in place.
+func (s *Scanner) InitRange(file *token.File, src []byte, start, end int, err ErrorHandler, mode Mode) {
+	s.file = file
+	s.src = src[:end]
+	s.err = err
+	s.mode = mode
+
+	s.ch = ' '
+	s.offset = start
+	s.rdOffset = start
+	s.cmdPos = true
+	s.ErrorCount = 0
+
+	s.next()
+	if start == 0 && s.ch == bom {
+		s.next() // ignore BOM at file beginning
+	}
+}
+
+func (s *Scanner) pos(offs int) token.Pos {
+	return s.file.Pos(offs)
+}
+
+func (s *Scanner) error(offs int, msg string) {
+	if s.err != nil {
+		s.err(s.file.Position(s.pos(offs)), msg)
+	}
+	s.ErrorCount++
+}
+
+func isDigit(ch rune) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isBlank(ch rune) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r'
+}
+
+// isSeparator reports whether ch ends an unquoted word.
+func isSeparator(ch rune) bool {
+	switch ch {
+	case eof, ' ', '\t', '\r', '\n', ';', '|', '<', '>', ')':
+		return true
+	}
+	return false
+}
+
+// skipWhitespace skips blanks and line continuations.
+func (s *Scanner) skipWhitespace() {
+	for {
+		switch {
+		case isBlank(s.ch):
+			s.next()
+		case s.ch == '\\' && s.peek() == '\n':
+			s.next()
+			s.next()
+		case s.ch == '\\' && s.peek() == '\r' && s.rdOffset+1 < len(s.src) && s.src[s.rdOffset+1] == '\n':
+			s.next()
+			s.next()
+			s.next()
+		default:
+			return
+		}
+	}
+}
+
+// scanComment scans a comment starting at '#' up to, but not
+// including, the end of the line.
+func (s *Scanner) scanComment() string {
+	offs := s.offset
+	for s.ch != '\n' && s.ch != eof {
+		s.next()
+	}
+	return string(s.src[offs:s.offset])
+}
+
+// scanSingleQuoted scans a '...' string. Only \' and \\ are
+// escapes inside it, but skipping any escaped character is enough
+// to find the closing quote.
+func (s *Scanner) scanSingleQuoted() {
+	offs := s.offset
+	s.next() // consume '
+	for {
+		switch s.ch {
+		case eof:
+			s.error(offs, "unexpected end of string, quotes are not balanced")
+			return
+		case '\\':
+			s.next()
+			if s.ch != eof {
+				s.next()
+			}
+		case '\'':
+			s.next()
+			return
+		default:
+			s.next()
+		}
+	}
+}
+
+// scanDoubleQuoted scans a "..." string, including any $(...)
+// command substitution nested inside it.
+func (s *Scanner) scanDoubleQuoted() {
+	offs := s.offset
+	s.next() // consume "
+	for {
+		switch s.ch {
+		case eof:
+			s.error(offs, "unexpected end of string, quotes are not balanced")
+			return
+		case '\\':
+			s.next()
+			if s.ch != eof {
+				s.next()
+			}
+		case '"':
+			s.next()
+			return
+		case '$':
+			s.next()
+			if s.ch == '(' {
+				s.scanSubst()
+			}
+		default:
+			s.next()
+		}
+	}
+}
+
+// scanSubst scans a command substitution from its opening '('
+// through the matching ')'.
+func (s *Scanner) scanSubst() {
+	offs := s.offset
+	s.next() // consume (
+	depth := 1
+	for {
+		switch s.ch {
+		case eof:
+			s.error(offs, "unexpected end of string, parentheses do not match")
+			return
+		case '\\':
+			s.next()
+			if s.ch != eof {
+				s.next()
+			}
+		case '\'':
+			s.scanSingleQuoted()
+		case '"':
+			s.scanDoubleQuoted()
+		case '(':
+			depth++
+			s.next()
+		case ')':
+			s.next()
+			if depth--; depth == 0 {
+				return
+			}
+		default:
+			s.next()
+		}
+	}
+}
+
+// scanWord scans an unquoted word and returns its raw text. Quoted
+// strings, escapes and command substitutions are consumed as part of
+// the word; blanks are allowed inside braces and index brackets.
+func (s *Scanner) scanWord() string {
+	offs := s.offset
+	braces := 0     // depth of open '{'
+	braceOffs := -1 // offset of the outermost open '{'
+	bracketOffs := -1
+	for {
+		switch s.ch {
+		case '\\':
+			s.next()
+			if s.ch != eof {
+				s.next()
+			}
+			continue
+		case '\'':
+			s.scanSingleQuoted()
+			continue
+		case '"':
+			s.scanDoubleQuoted()
+			continue
+		case '(':
+			s.scanSubst()
+			continue
+		case '{':
+			if braces == 0 {
+				braceOffs = s.offset
+			}
+			braces++
+			s.next()
+			continue
+		case '}':
+			if braces > 0 {
+				braces--
+			}
+			s.next()
+			continue
+		case '[':
+			if s.offset != offs && bracketOffs < 0 {
+				bracketOffs = s.offset
+			}
+			s.next()
+			continue
+		case ']':
+			bracketOffs = -1
+			s.next()
+			continue
+		case '&':
+			// A trailing '&' is the background operator, but
+			// fish keeps "a&b" together as a single word.
+			if next := rune(s.peek()); isSeparator(next) || next == '&' || next == 0 {
+				break
+			}
+			s.next()
+			continue
+		case ' ', '\t':
+			if braces > 0 || bracketOffs >= 0 {
+				s.next()
+				continue
+			}
+		}
+		if isSeparator(s.ch) || s.ch == '&' {
+			break
+		}
+		s.next()
+	}
+	if braces > 0 {
+		s.error(braceOffs, "unexpected end of string, curly braces do not match")
+	}
+	if bracketOffs >= 0 {
+		s.error(bracketOffs, "unexpected end of string, square brackets do not match")
+	}
+	return string(s.src[offs:s.offset])
+}
+
+// isFdRedirect reports whether the digits at the current position
+// are the file descriptor of a redirection, as in "2>file".
+func (s *Scanner) isFdRedirect() bool {
+	i := s.offset
+	for i < len(s.src) && isDigit(rune(s.src[i])) {
+		i++
+	}
+	return i < len(s.src) && (s.src[i] == '<' || s.src[i] == '>')
+}
+
+// scanRedirect scans a redirection or fd pipe operator whose optional
+// file descriptor starts at offs. The current character is '<' or '>'.
+func (s *Scanner) scanRedirect(offs int) token.Token {
+	var tok token.Token
+	if s.ch == '<' {
+		s.next()
+		switch s.ch {
+		case '&':
+			s.next()
+			tok = token.LT_BITAND
+		case '?':
+			s.next()
+			tok = token.LT_QUEST
+		default:
+			tok = token.LT
+		}
+		return tok
+	}
+	s.next() // consume >
+	switch s.ch {
+	case '>':
+		s.next()
+		tok = token.DOUBLE_GT
+	case '&':
+		s.next()
+		tok = token.LT_AND
+	case '?':
+		s.next()
+		tok = token.GT_QUEST
+	case '|':
+		s.next()
+		tok = token.GT_PIPE
+	default:
+		tok = token.GT
+	}
+	return tok
+}
+
+// Scan scans the next token and returns the token position, the token,
+// and its literal string if applicable. The source end is indicated by
+// token.EOF.
+//
+// Words are returned as token.WORD with their raw source text as literal,
+// unless they appear in command position and spell a keyword, in which
+// case the keyword token is returned. A "!" in command position is
+// returned as token.BITNOT.
+//
+// Both ";" and newlines are returned as token.SEMI; the literal is ";"
+// or "\n" respectively. Redirections and fd pipes such as "2>>" or "2>|"
+// keep their file descriptor in the literal.
+//
+// A "^" or "^^" starting a word is returned as token.XOR or
+// token.DOUBLE_XOR if the CaretRedirects mode is set; otherwise it is
+// part of the word, as in current versions of fish.
+//
+// Comments are skipped unless the ScanComments mode is set, in which case
+// they are returned as token.COMMENT with the leading '#' in the literal.
+func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
+scanAgain:
+	s.skipWhitespace()
+
+	offs := s.offset
+	pos = s.pos(offs)
+	cmdPos := s.cmdPos
+	s.cmdPos = false
+
+	switch ch := s.ch; {
+	case ch == eof:
+		tok = token.EOF
+		s.cmdPos = cmdPos
+	case ch == '\n' || ch == ';':
+		s.next()
+		tok, lit = token.SEMI, string(ch)
+		s.cmdPos = true
+	case ch == '#':
+		lit = s.scanComment()
+		s.cmdPos = cmdPos
+		if s.mode&ScanComments == 0 {
+			goto scanAgain
+		}
+		tok = token.COMMENT
+	case ch == '|':
+		s.next()
+		if s.ch == '|' {
+			s.next()
+			tok = token.OR
+		} else {
+			tok = token.BITOR
+		}
+		s.cmdPos = true
+	case ch == '&':
+		s.next()
+		switch s.ch {
+		case '&':
+			s.next()
+			tok = token.AND
+			s.cmdPos = true
+		case '|':
+			s.next()
+			tok = token.AND_PIPE
+			s.cmdPos = true
+		case '>':
+			s.next()
+			if s.ch == '>' {
+				s.next()
+				tok = token.AND_DOUBLE_GT
+			} else {
+				tok = token.AND_LT
+			}
+		default:
+			tok = token.BITAND
+			s.cmdPos = true
+		}
+	case ch == '<' || ch == '>':
+		tok = s.scanRedirect(offs)
+		s.cmdPos = tok == token.GT_PIPE
+	case ch == '^' && !cmdPos && s.mode&CaretRedirects != 0:
+		s.next()
+		if s.ch == '^' {
+			s.next()
+			tok = token.DOUBLE_XOR
+		} else {
+			tok = token.XOR
+		}
+	case isDigit(ch) && s.isFdRedirect():
+		for isDigit(s.ch) {
+			s.next()
+		}
+		tok = s.scanRedirect(offs)
+		s.cmdPos = tok == token.GT_PIPE
+	case ch == ')':
+		s.next()
+		s.error(offs, "unexpected ')' for unopened parenthesis")
+		tok = token.ILLEGAL
+	default:
+		lit = s.scanWord()
+		tok = token.WORD
+		if cmdPos {
+			if lit == "!" {
+				tok = token.BITNOT
+			} else {
+				tok = token.Lookup(lit)
+			}
+		}
+		switch tok {
+		case token.IF, token.ELSE, token.WHILE, token.BEGIN,
+			token.AND_KW, token.OR_KW, token.NOT_KW, token.BITNOT:
+			s.cmdPos = true
+		}
+		return
+	}
+	if lit == "" {
+		lit = string(s.src[offs:s.offset])
+	}
+	return
+}
@@ -0,0 +1,199 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package scanner_test
+
+import (
+	"testing"
+
+	"github.com/hulo-io/fishparser/scanner"
+	"github.com/hulo-io/fishparser/token"
+)
+
+type elt struct {
+	tok token.Token
+	lit string
+}
+
+func initScanner(s *scanner.Scanner, src string, err scanner.ErrorHandler, mode scanner.Mode) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("x.fish", fset.Base(), len(src))
+	s.Init(file, []byte(src), err, mode)
+}
+
+func scanAll(t *testing.T, src string, mode scanner.Mode) []elt {
+	t.Helper()
+	var s scanner.Scanner
+	initScanner(&s, src, func(pos token.Position, msg string) {
+		t.Errorf("%q: unexpected error at %s: %s", src, pos, msg)
+	}, mode)
+	var res []elt
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return res
+		}
+		res = append(res, elt{tok, lit})
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src  string
+		mode scanner.Mode
+		want []elt
+	}{
+		{"echo hello world", 0, []elt{
+			{token.WORD, "echo"}, {token.WORD, "hello"}, {token.WORD, "world"},
+		}},
+		{"if test -d $dir; echo if; end", 0, []elt{
+			{token.IF, "if"}, {token.WORD, "test"}, {token.WORD, "-d"}, {token.WORD, "$dir"}, {token.SEMI, ";"},
+			{token.WORD, "echo"}, {token.WORD, "if"}, {token.SEMI, ";"},
+			{token.END, "end"},
+		}},
+		{"else if not true\n", 0, []elt{
+			{token.ELSE, "else"}, {token.IF, "if"}, {token.NOT_KW, "not"}, {token.WORD, "true"}, {token.SEMI, "\n"},
+		}},
+		{"! test -f x && echo a || echo b", 0, []elt{
+			{token.BITNOT, "!"}, {token.WORD, "test"}, {token.WORD, "-f"}, {token.WORD, "x"},
+			{token.AND, "&&"}, {token.WORD, "echo"}, {token.WORD, "a"},
+			{token.OR, "||"}, {token.WORD, "echo"}, {token.WORD, "b"},
+		}},
+		{"echo 'a b' \"c $d\" e\\ f", 0, []elt{
+			{token.WORD, "echo"}, {token.WORD, "'a b'"}, {token.WORD, `"c $d"`}, {token.WORD, `e\ f`},
+		}},
+		{"set x (echo (pwd); ls) $(date)\"$(id -u)\"", 0, []elt{
+			{token.WORD, "set"}, {token.WORD, "x"}, {token.WORD, "(echo (pwd); ls)"}, {token.WORD, `$(date)"$(id -u)"`},
+		}},
+		{"echo {a, b}/$list[1 2]", 0, []elt{
+			{token.WORD, "echo"}, {token.WORD, "{a, b}/$list[1 2]"},
+		}},
+		{"echo a \\\n  b # trailing\n", scanner.ScanComments, []elt{
+			{token.WORD, "echo"}, {token.WORD, "a"}, {token.WORD, "b"}, {token.COMMENT, "# trailing"}, {token.SEMI, "\n"},
+		}},
+		{"echo a#b # c", 0, []elt{
+			{token.WORD, "echo"}, {token.WORD, "a#b"},
+		}},
+		{"cmd <in >out >>log 2>err 2>&1 &>all &>>all >?new <?maybe", 0, []elt{
+			{token.WORD, "cmd"}, {token.LT, "<"}, {token.WORD, "in"}, {token.GT, ">"}, {token.WORD, "out"},
+			{token.DOUBLE_GT, ">>"}, {token.WORD, "log"}, {token.GT, "2>"}, {token.WORD, "err"},
+			{token.LT_AND, "2>&"}, {token.WORD, "1"}, {token.AND_LT, "&>"}, {token.WORD, "all"},
+			{token.AND_DOUBLE_GT, "&>>"}, {token.WORD, "all"}, {token.GT_QUEST, ">?"}, {token.WORD, "new"},
+			{token.LT_QUEST, "<?"}, {token.WORD, "maybe"},
+		}},
+		{"a | b 2>| c &| d &", 0, []elt{
+			{token.WORD, "a"}, {token.BITOR, "|"}, {token.WORD, "b"}, {token.GT_PIPE, "2>|"},
+			{token.WORD, "c"}, {token.AND_PIPE, "&|"}, {token.WORD, "d"}, {token.BITAND, "&"},
+		}},
+		{"cmd ^err ^^log a^b", scanner.CaretRedirects, []elt{
+			{token.WORD, "cmd"}, {token.XOR, "^"}, {token.WORD, "err"},
+			{token.DOUBLE_XOR, "^^"}, {token.WORD, "log"}, {token.WORD, "a^b"},
+		}},
+		{"echo ^err", 0, []elt{
+			{token.WORD, "echo"}, {token.WORD, "^err"},
+		}},
+		{"echo a&b", 0, []elt{
+			{token.WORD, "echo"}, {token.WORD, "a&b"},
+		}},
+		{"function end; and begin; end", 0, []elt{
+			{token.FUNCTION, "function"}, {token.WORD, "end"}, {token.SEMI, ";"},
+			{token.AND_KW, "and"}, {token.BEGIN, "begin"}, {token.SEMI, ";"}, {token.END, "end"},
+		}},
+	}
+	for _, tt := range tests {
+		got := scanAll(t, tt.src, tt.mode)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.src, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: token %d: got %v, want %v", tt.src, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestScanPos(t *testing.T) {
+	src := "if true\n  echo $x\nend"
+	want := []token.Pos{1, 4, 8, 11, 16, 18, 19}
+
+	var s scanner.Scanner
+	initScanner(&s, src, nil, 0)
+	for i, w := range want {
+		pos, tok, lit := s.Scan()
+		if pos != w {
+			t.Errorf("token %d (%s %q): got pos %d, want %d", i, tok, lit, pos, w)
+		}
+	}
+	if _, tok, _ := s.Scan(); tok != token.EOF {
+		t.Errorf("got %s, want EOF", tok)
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos string
+		msg string
+	}{
+		{"echo 'abc", "x.fish:1:6", "unexpected end of string, quotes are not balanced"},
+		{`echo "a $(b`, "x.fish:1:10", "unexpected end of string, parentheses do not match"},
+		{"echo (a", "x.fish:1:6", "unexpected end of string, parentheses do not match"},
+		{"echo a)", "x.fish:1:7", "unexpected ')' for unopened parenthesis"},
+		{"echo {a,b", "x.fish:1:6", "unexpected end of string, curly braces do not match"},
+		{"echo a\\\n  b\necho 'c\nd", "x.fish:3:6", "unexpected end of string, quotes are not balanced"},
+	}
+	for _, tt := range tests {
+		var s scanner.Scanner
+		var pos token.Position
+		var msg string
+		initScanner(&s, tt.src, func(p token.Position, m string) {
+			if msg == "" {
+				pos, msg = p, m
+			}
+		}, 0)
+		for {
+			if _, tok, _ := s.Scan(); tok == token.EOF {
+				break
+			}
+		}
+		if pos.String() != tt.pos || msg != tt.msg {
+			t.Errorf("%q: got error %s %q, want %s %q", tt.src, pos, msg, tt.pos, tt.msg)
+		}
+		if s.ErrorCount == 0 {
+			t.Errorf("%q: ErrorCount = 0", tt.src)
+		}
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	var list scanner.ErrorList
+	list.Add(token.Position{Filename: "b.fish", Line: 1, Column: 1}, "b")
+	list.Add(token.Position{Filename: "a.fish", Line: 3, Column: 9}, "second on line 3")
+	list.Add(token.Position{Filename: "a.fish", Line: 3, Column: 2}, "first on line 3")
+	list.Add(token.Position{Filename: "a.fish", Line: 1, Column: 5}, "a")
+
+	list.RemoveMultiples()
+	want := []string{
+		"a.fish:1:5: a",
+		"a.fish:3:2: first on line 3",
+		"b.fish:1:1: b",
+	}
+	if len(list) != len(want) {
+		t.Fatalf("got %d errors, want %d", len(list), len(want))
+	}
+	for i, e := range list {
+		if e.Error() != want[i] {
+			t.Errorf("error %d: got %s, want %s", i, e, want[i])
+		}
+	}
+	if got := list.Error(); got != "a.fish:1:5: a (and 2 more errors)" {
+		t.Errorf("got %q", got)
+	}
+
+	list.Reset()
+	if list.Err() != nil {
+		t.Errorf("empty list reports %v", list.Err())
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2025 The Hulo Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+package token_test
+
+import (
+	"testing"
+
+	"github.com/hulo-io/fishparser/token"
+)
+
+func TestPosition(t *testing.T) {
+	fset := token.NewFileSet()
+
+	config := "set -x EDITOR vim\n\nif status is-interactive\n    fish_vi_key_bindings\nend\n"
+	f1 := fset.AddFile("config.fish", fset.Base(), len(config))
+	f1.SetLinesForContent([]byte(config))
+
+	greet := "function greet\n    echo hi\nend\n"
+	f2 := fset.AddFile("greet.fish", fset.Base(), len(greet))
+	for i, c := range greet {
+		if c == '\n' {
+			f2.AddLine(i + 1)
+		}
+	}
+
+	if got := f1.LineCount(); got != 5 {
+		t.Errorf("config.fish has %d lines, want 5", got)
+	}
+	if got := f1.LineStart(4); f1.Offset(got) != 44 {
+		t.Errorf("line 4 of config.fish starts at offset %d, want 44", f1.Offset(got))
+	}
+
+	tests := []struct {
+		pos  token.Pos
+		want string
+	}{
+		{token.NoPos, "-"},
+		{f1.Pos(0), "config.fish:1:1"},
+		{f1.Pos(23), "config.fish:3:5"},
+		{f1.Pos(48), "config.fish:4:5"},
+		{f2.Pos(0), "greet.fish:1:1"},
+		{f2.Pos(19), "greet.fish:2:5"},
+		{f2.Pos(len(greet)), "greet.fish:3:5"},
+	}
+	for _, tt := range tests {
+		if got := fset.Position(tt.pos).String(); got != tt.want {
+			t.Errorf("Position(%d) = %s, want %s", tt.pos, got, tt.want)
+		}
+	}
+
+	if fset.File(f2.Pos(3)) != f2 || fset.File(f1.Pos(len(config))) != f1 {
+		t.Error("positions resolved to the wrong file")
+	}
+}
@@ -54,7 +54,10 @@ const (
 	SINGLE_QUOTE = "'"
 	DOUBLE_QUOTE = "\""
 
-	BACK_QUOTE = "`"
+	GT_QUEST      = ">?"
+	LT_QUEST      = "<?"
+	LT_BITAND     = "<&"
+	AND_DOUBLE_GT = "&>>"
 
 	LT = "<"
 	GT = ">"
@@ -98,9 +101,13 @@ const (
 
 	DOUBLE_SEMI = ";;"
 
-	AND = "&&"
-	OR  = "||"
-	XOR = "^"
+	AND        = "&&"
+	OR         = "||"
+	XOR        = "^"
+	DOUBLE_XOR = "^^"
+
+	AND_PIPE = "&|"
+	GT_PIPE  = ">|"
 
 	IF       = "if"
 	ELSE     = "else"
@@ -117,10 +124,75 @@ const (
 	BREAK    = "break"
 	CONTINUE = "continue"
 	END      = "end"
+	BEGIN    = "begin"
+	AND_KW   = "and"
+	OR_KW    = "or"
+	NOT_KW   = "not"
 
 	STRING = "STRING"
 	NUMBER = "NUMBER"
 	WORD   = "WORD"
 
+	COMMENT = "COMMENT"
+	ILLEGAL = "ILLEGAL"
+
 	EOF = "EOF"
 )
+
+var keywords = map[string]Token{
+	IF:       IF,
+	ELSE:     ELSE,
+	FOR:      FOR,
+	WHILE:    WHILE,
+	SWITCH:   SWITCH,
+	CASE:     CASE,
+	FUNCTION: FUNCTION,
+	RETURN:   RETURN,
+	BREAK:    BREAK,
+	CONTINUE: CONTINUE,
+	END:      END,
+	BEGIN:    BEGIN,
+	AND_KW:   AND_KW,
+	OR_KW:    OR_KW,
+	NOT_KW:   NOT_KW,
+}
+
+// Lookup maps a word in command position to its keyword token,
+// or WORD if the word is not a keyword.
+func Lookup(word string) Token {
+	if tok, ok := keywords[word]; ok {
+		return tok
+	}
+	return WORD
+}
+
+// IsKeyword reports whether name is a fish keyword, such as "if" or "end".
+// Fish only treats these words as keywords in command position.
+func IsKeyword(name string) bool {
+	_, ok := keywords[name]
+	return ok
+}
+
+// IsRedirect reports whether tok is a redirection operator.
+func (tok Token) IsRedirect() bool {
+	switch tok {
+	case LT, GT, DOUBLE_GT, LT_AND, LT_BITAND, AND_LT, AND_DOUBLE_GT, GT_QUEST, LT_QUEST,
+		XOR, DOUBLE_XOR:
+		return true
+	}
+	return false
+}
+
+// DefaultFd returns the file descriptor that the redirection operator
+// tok applies to when none is written before it: 0 for the input
+// redirections, 2 for the legacy "^" and "^^" and 1 for the others.
+// The "&>" and "&>>" redirections apply to 2 as well as to 1.
+func (tok Token) DefaultFd() int {
+	switch tok {
+	case LT, LT_BITAND, LT_QUEST:
+		return 0
+	case XOR, DOUBLE_XOR:
+		return 2
+	}
+	return 1
+}